@@ -6,5 +6,6 @@ import (
 
 // Default modes
 var (
-	DefaultDirMode os.FileMode = 0755
+	DefaultDirMode  os.FileMode = 0755
+	DefaultFileMode os.FileMode = 0644
 )
@@ -1,9 +1,13 @@
 package astikit
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"math"
+	"math/bits"
 )
 
 // BitsWriter represents an object that can write individual bits into a writer
@@ -11,19 +15,34 @@ import (
 // This is particularly helpful when you want to build a slice of bytes based
 // on individual bits for testing purposes.
 type BitsWriter struct {
-	bo       binary.ByteOrder
-	cache    byte
-	cacheLen byte
-	bsCache  []byte
-	w        io.Writer
-	writeCb  BitsWriterWriteCallback
+	annotations   []BitsAnnotation
+	bo            binary.ByteOrder
+	bytesWritten  uint64
+	cache         byte
+	cacheLen      byte
+	bsCache       []byte
+	checkOverflow bool
+	flushPattern  byte
+	w             io.Writer
+	writeCb       BitsWriterWriteCallback
 }
 
 type BitsWriterWriteCallback func([]byte)
 
+// BitsAnnotation represents a named field starting at a bit position
+type BitsAnnotation struct {
+	Name     string
+	Position uint64
+}
+
 // BitsWriterOptions represents BitsWriter options
 type BitsWriterOptions struct {
 	ByteOrder binary.ByteOrder
+	// By default WriteN silently truncates uint8/uint16/uint32/uint64 values that don't fit
+	// in n bits. If CheckOverflow is true, a BitsWriterOverflowError is returned instead.
+	CheckOverflow bool
+	// FlushPattern is used by Flush to fill the remaining bits of a partial byte
+	FlushPattern byte
 	// WriteCallback is called every time when full byte is written
 	WriteCallback BitsWriterWriteCallback
 	Writer        io.Writer
@@ -32,10 +51,12 @@ type BitsWriterOptions struct {
 // NewBitsWriter creates a new BitsWriter
 func NewBitsWriter(o BitsWriterOptions) (w *BitsWriter) {
 	w = &BitsWriter{
-		bo:      o.ByteOrder,
-		bsCache: make([]byte, 1),
-		w:       o.Writer,
-		writeCb: o.WriteCallback,
+		bo:            o.ByteOrder,
+		bsCache:       make([]byte, 1),
+		checkOverflow: o.CheckOverflow,
+		flushPattern:  o.FlushPattern,
+		w:             o.Writer,
+		writeCb:       o.WriteCallback,
 	}
 	if w.bo == nil {
 		w.bo = binary.BigEndian
@@ -55,6 +76,8 @@ func (w *BitsWriter) SetWriteCallback(cb BitsWriterWriteCallback) {
 //   - []byte: processed as n bytes, n being the length of the input
 //   - bool: processed as one bit
 //   - uint8/uint16/uint32/uint64: processed as n bits, if type is uintn
+//   - int8/int16/int32/int64: processed as n bits using two's complement, if type is intn
+//   - uint: processed as n bits, n being the platform's native uint size
 func (w *BitsWriter) Write(i any) error {
 	// Transform input into "10010" format
 
@@ -87,6 +110,16 @@ func (w *BitsWriter) Write(i any) error {
 		return w.writeFullInt(uint64(a), 4)
 	case uint64:
 		return w.writeFullInt(a, 8)
+	case uint:
+		return w.writeFullInt(uint64(a), bits.UintSize/8)
+	case int8:
+		return w.writeFullByte(uint8(a))
+	case int16:
+		return w.writeFullInt(uint64(a), 2)
+	case int32:
+		return w.writeFullInt(uint64(a), 4)
+	case int64:
+		return w.writeFullInt(uint64(a), 8)
 	default:
 		return errors.New("astikit: invalid type")
 	}
@@ -120,6 +153,53 @@ func (w *BitsWriter) WriteBytesN(bs []byte, n int, padByte uint8) error {
 	return nil
 }
 
+// BitsWritten returns the number of bits written so far, including the ones that
+// have not been flushed to the writer yet
+func (w *BitsWriter) BitsWritten() uint64 {
+	return w.bytesWritten*8 + uint64(w.cacheLen)
+}
+
+// Annotate records that the field named name starts at the current bit position.
+// Annotations can then be provided to BytesDiff to pinpoint which fields differ.
+func (w *BitsWriter) Annotate(name string) {
+	w.annotations = append(w.annotations, BitsAnnotation{
+		Name:     name,
+		Position: w.BitsWritten(),
+	})
+}
+
+// Annotations returns the annotations recorded so far
+func (w *BitsWriter) Annotations() []BitsAnnotation {
+	return w.annotations
+}
+
+// IsAligned checks whether the writer is on a byte boundary
+func (w *BitsWriter) IsAligned() bool {
+	return w.cacheLen == 0
+}
+
+// WriteAlignPadding writes bits until the writer is on a byte boundary. Bits are
+// taken from the pattern at the same positions, therefore 0xff pads with ones and
+// 0x00 pads with zeros. It does nothing if the writer is already aligned.
+func (w *BitsWriter) WriteAlignPadding(pattern byte) error {
+	if w.cacheLen == 0 {
+		return nil
+	}
+	w.bsCache[0] = w.cache | pattern&(0xff>>w.cacheLen)
+	if err := w.flushBsCache(); err != nil {
+		return err
+	}
+	w.cacheLen = 0
+	w.cache = 0
+	return nil
+}
+
+// Flush writes the partial byte, if any, filling its remaining bits with the
+// FlushPattern option
+func (w *BitsWriter) Flush() error {
+	return w.WriteAlignPadding(w.flushPattern)
+}
+
 func (w *BitsWriter) writeByteSlice(in []byte) error {
 	if len(in) == 0 {
 		return nil
@@ -142,6 +222,7 @@ func (w *BitsWriter) write(b []byte) error {
 	if _, err := w.w.Write(b); err != nil {
 		return err
 	}
+	w.bytesWritten += uint64(len(b))
 	if w.writeCb != nil {
 		for i := range b {
 			w.writeCb(b[i : i+1])
@@ -243,25 +324,167 @@ func (w *BitsWriter) writeBitsN(toWrite uint64, n int) (err error) {
 	return
 }
 
+// BitsWriterOverflowError is returned when a value doesn't fit in the number of bits
+// it should be written into
+type BitsWriterOverflowError struct {
+	N     int
+	Value any
+}
+
+func (err BitsWriterOverflowError) Error() string {
+	return fmt.Sprintf("astikit: value %v overflows %d bits", err.Value, err.N)
+}
+
 // WriteN writes the input into n bits
+// Available types are:
+//   - uint8/uint16/uint32/uint64: silently truncated to n bits unless the CheckOverflow
+//     option is true
+//   - uint: range-checked against n bits
+//   - int8/int16/int32/int64: written using two's complement and range-checked
+//     against n bits
+//
+// A BitsWriterOverflowError is returned when a range-checked value doesn't fit in n bits
 func (w *BitsWriter) WriteN(i any, n int) error {
 	var toWrite uint64
+	var check, signed bool
+	var v int64
 	switch a := i.(type) {
 	case uint8:
 		toWrite = uint64(a)
+		check = w.checkOverflow
 	case uint16:
 		toWrite = uint64(a)
+		check = w.checkOverflow
 	case uint32:
 		toWrite = uint64(a)
+		check = w.checkOverflow
 	case uint64:
 		toWrite = a
+		check = w.checkOverflow
+	case uint:
+		toWrite = uint64(a)
+		check = true
+	case int8:
+		v, signed = int64(a), true
+	case int16:
+		v, signed = int64(a), true
+	case int32:
+		v, signed = int64(a), true
+	case int64:
+		v, signed = a, true
 	default:
 		return errors.New("astikit: invalid type")
 	}
 
+	if signed {
+		if n < 64 && (n <= 0 && v != 0 || n > 0 && (v < -(1<<(n-1)) || v >= 1<<(n-1))) {
+			return BitsWriterOverflowError{N: n, Value: i}
+		}
+		toWrite = uint64(v)
+	} else if check && n < 64 && (n <= 0 && toWrite != 0 || n > 0 && toWrite>>n != 0) {
+		return BitsWriterOverflowError{N: n, Value: i}
+	}
+
 	return w.writeBitsN(toWrite, n)
 }
 
+// WriteExpGolomb writes the input as an unsigned Exp-Golomb code, also known as ue(v)
+func (w *BitsWriter) WriteExpGolomb(i uint64) error {
+	if i == math.MaxUint64 {
+		return errors.New("astikit: value is too big to be Exp-Golomb coded")
+	}
+	n := bits.Len64(i+1) - 1
+	if err := w.writeBitsN(0, n); err != nil {
+		return err
+	}
+	return w.writeBitsN(i+1, n+1)
+}
+
+// WriteSignedExpGolomb writes the input as a signed Exp-Golomb code, also known as se(v)
+func (w *BitsWriter) WriteSignedExpGolomb(i int64) error {
+	if i == math.MinInt64 {
+		return errors.New("astikit: value is too small to be Exp-Golomb coded")
+	}
+	if i > 0 {
+		return w.WriteExpGolomb(uint64(i)<<1 - 1)
+	}
+	return w.WriteExpGolomb(uint64(-i) << 1)
+}
+
+// WriteULEB128 writes the input as an unsigned LEB128
+func (w *BitsWriter) WriteULEB128(i uint64) error {
+	for {
+		b := uint8(i & 0x7f)
+		i >>= 7
+		if i != 0 {
+			b |= 0x80
+		}
+		if err := w.writeFullByte(b); err != nil {
+			return err
+		}
+		if i == 0 {
+			return nil
+		}
+	}
+}
+
+// WriteSLEB128 writes the input as a signed LEB128
+func (w *BitsWriter) WriteSLEB128(i int64) error {
+	for {
+		b := uint8(i & 0x7f)
+		i >>= 7
+		done := (i == 0 && b&0x40 == 0) || (i == -1 && b&0x40 != 0)
+		if !done {
+			b |= 0x80
+		}
+		if err := w.writeFullByte(b); err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// WriteQUICVarint writes the input as a QUIC variable-length integer (RFC 9000) using
+// the smallest possible encoding
+func (w *BitsWriter) WriteQUICVarint(i uint64) error {
+	switch {
+	case i <= 63:
+		return w.writeBitsN(i, 8)
+	case i <= 16383:
+		return w.writeBitsN(1<<14|i, 16)
+	case i <= 1073741823:
+		return w.writeBitsN(2<<30|i, 32)
+	case i <= 4611686018427387903:
+		return w.writeBitsN(3<<62|i, 64)
+	default:
+		return errors.New("astikit: value is too big to be QUIC varint coded")
+	}
+}
+
+// WriteExpandableSize writes the input as an MPEG-4 descriptor length (ISO/IEC 14496-1
+// expandable class size) using the smallest possible encoding
+func (w *BitsWriter) WriteExpandableSize(i uint32) error {
+	if i >= 1<<28 {
+		return errors.New("astikit: value is too big to be coded as an expandable size")
+	}
+	n := 1
+	for i>>(7*n) > 0 {
+		n++
+	}
+	for n--; n >= 0; n-- {
+		b := uint8(i>>(7*n)) & 0x7f
+		if n > 0 {
+			b |= 0x80
+		}
+		if err := w.writeFullByte(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // BitsWriterBatch allows to chain multiple Write* calls and check for error only once
 // For more info see https://github.com/asticode/go-astikit/pull/6
 type BitsWriterBatch struct {
@@ -282,6 +505,18 @@ func (b *BitsWriterBatch) Write(i any) {
 	}
 }
 
+// Calls BitsWriter.Annotate if there was no write error before
+func (b *BitsWriterBatch) Annotate(name string) {
+	if b.err == nil {
+		b.w.Annotate(name)
+	}
+}
+
+// Calls BitsWriter.Annotations
+func (b *BitsWriterBatch) Annotations() []BitsAnnotation {
+	return b.w.Annotations()
+}
+
 // Calls BitsWriter.WriteN if there was no write error before
 func (b *BitsWriterBatch) WriteN(i any, n int) {
 	if b.err == nil {
@@ -296,11 +531,485 @@ func (b *BitsWriterBatch) WriteBytesN(bs []byte, n int, padByte uint8) {
 	}
 }
 
+// Calls BitsWriter.WriteExpGolomb if there was no write error before
+func (b *BitsWriterBatch) WriteExpGolomb(i uint64) {
+	if b.err == nil {
+		b.err = b.w.WriteExpGolomb(i)
+	}
+}
+
+// Calls BitsWriter.WriteSignedExpGolomb if there was no write error before
+func (b *BitsWriterBatch) WriteSignedExpGolomb(i int64) {
+	if b.err == nil {
+		b.err = b.w.WriteSignedExpGolomb(i)
+	}
+}
+
+// Calls BitsWriter.WriteULEB128 if there was no write error before
+func (b *BitsWriterBatch) WriteULEB128(i uint64) {
+	if b.err == nil {
+		b.err = b.w.WriteULEB128(i)
+	}
+}
+
+// Calls BitsWriter.WriteSLEB128 if there was no write error before
+func (b *BitsWriterBatch) WriteSLEB128(i int64) {
+	if b.err == nil {
+		b.err = b.w.WriteSLEB128(i)
+	}
+}
+
+// Calls BitsWriter.WriteQUICVarint if there was no write error before
+func (b *BitsWriterBatch) WriteQUICVarint(i uint64) {
+	if b.err == nil {
+		b.err = b.w.WriteQUICVarint(i)
+	}
+}
+
+// Calls BitsWriter.WriteExpandableSize if there was no write error before
+func (b *BitsWriterBatch) WriteExpandableSize(i uint32) {
+	if b.err == nil {
+		b.err = b.w.WriteExpandableSize(i)
+	}
+}
+
+// Calls BitsWriter.WriteAlignPadding if there was no write error before
+func (b *BitsWriterBatch) WriteAlignPadding(pattern byte) {
+	if b.err == nil {
+		b.err = b.w.WriteAlignPadding(pattern)
+	}
+}
+
+// Calls BitsWriter.Flush if there was no write error before
+func (b *BitsWriterBatch) Flush() {
+	if b.err == nil {
+		b.err = b.w.Flush()
+	}
+}
+
 // Returns first write error
 func (b *BitsWriterBatch) Err() error {
 	return b.err
 }
 
+// BitsReader represents an object that can read individual bits from a reader
+// in a developer-friendly way. It is the counterpart of BitsWriter.
+type BitsReader struct {
+	bo       binary.ByteOrder
+	cache    byte
+	cacheLen byte
+	bsCache  []byte
+	r        io.Reader
+	readCb   BitsReaderReadCallback
+}
+
+type BitsReaderReadCallback func([]byte)
+
+// BitsReaderOptions represents BitsReader options
+type BitsReaderOptions struct {
+	ByteOrder binary.ByteOrder
+	// ReadCallback is called every time when full byte is read
+	ReadCallback BitsReaderReadCallback
+	Reader       io.Reader
+}
+
+// NewBitsReader creates a new BitsReader
+func NewBitsReader(o BitsReaderOptions) (r *BitsReader) {
+	r = &BitsReader{
+		bo:      o.ByteOrder,
+		bsCache: make([]byte, 1),
+		r:       o.Reader,
+		readCb:  o.ReadCallback,
+	}
+	if r.bo == nil {
+		r.bo = binary.BigEndian
+	}
+	return
+}
+
+// NewBitsReaderFromBytes creates a new BitsReader reading from a slice of bytes.
+// The Reader option is ignored.
+func NewBitsReaderFromBytes(bs []byte, o BitsReaderOptions) *BitsReader {
+	o.Reader = bytes.NewReader(bs)
+	return NewBitsReader(o)
+}
+
+// bitsLeft returns the number of bits left to read, if the underlying reader knows its length
+func (r *BitsReader) bitsLeft() (int, bool) {
+	l, ok := r.r.(interface{ Len() int })
+	if !ok {
+		return 0, false
+	}
+	return l.Len()*8 + int(r.cacheLen), true
+}
+
+func (r *BitsReader) SetReadCallback(cb BitsReaderReadCallback) {
+	r.readCb = cb
+}
+
+func (r *BitsReader) read(bs []byte) error {
+	if _, err := io.ReadFull(r.r, bs); err != nil {
+		return err
+	}
+	if r.readCb != nil {
+		for i := range bs {
+			r.readCb(bs[i : i+1])
+		}
+	}
+	return nil
+}
+
+func (r *BitsReader) readFullByte() (byte, error) {
+	if err := r.read(r.bsCache); err != nil {
+		return 0, err
+	}
+	return r.bsCache[0], nil
+}
+
+func (r *BitsReader) readBitsN(n int) (o uint64, err error) {
+	for n > 0 {
+		if r.cacheLen == 0 {
+			var b byte
+			if b, err = r.readFullByte(); err != nil {
+				return
+			}
+			if n >= 8 {
+				o = o<<8 | uint64(b)
+				n -= 8
+				continue
+			}
+			r.cache = b
+			r.cacheLen = 8
+		}
+
+		m := n
+		if m > int(r.cacheLen) {
+			m = int(r.cacheLen)
+		}
+		r.cacheLen -= uint8(m)
+		o = o<<m | uint64(r.cache>>r.cacheLen)&(1<<m-1)
+		n -= m
+	}
+	return
+}
+
+func (r *BitsReader) readFullInt(len int) (o uint64, err error) {
+	if r.bo == binary.BigEndian {
+		return r.readBitsN(len * 8)
+	}
+	for i := 0; i < len; i++ {
+		var b uint64
+		if b, err = r.readBitsN(8); err != nil {
+			return
+		}
+		o |= b << (i * 8)
+	}
+	return
+}
+
+// ReadBit reads one bit
+func (r *BitsReader) ReadBit() (uint8, error) {
+	o, err := r.readBitsN(1)
+	return uint8(o), err
+}
+
+// ReadBool reads one bit as a bool
+func (r *BitsReader) ReadBool() (bool, error) {
+	o, err := r.readBitsN(1)
+	return o == 1, err
+}
+
+// ReadN reads n bits, n being between 0 and 64. Bits are always read from
+// left to right, regardless of the byte order.
+func (r *BitsReader) ReadN(n int) (uint64, error) {
+	if n < 0 || n > 64 {
+		return 0, fmt.Errorf("astikit: invalid number of bits %d", n)
+	}
+	return r.readBitsN(n)
+}
+
+// ReadUint8 reads 8 bits
+func (r *BitsReader) ReadUint8() (uint8, error) {
+	o, err := r.readBitsN(8)
+	return uint8(o), err
+}
+
+// ReadUint16 reads 16 bits using the byte order
+func (r *BitsReader) ReadUint16() (uint16, error) {
+	o, err := r.readFullInt(2)
+	return uint16(o), err
+}
+
+// ReadUint32 reads 32 bits using the byte order
+func (r *BitsReader) ReadUint32() (uint32, error) {
+	o, err := r.readFullInt(4)
+	return uint32(o), err
+}
+
+// ReadUint64 reads 64 bits using the byte order
+func (r *BitsReader) ReadUint64() (uint64, error) {
+	return r.readFullInt(8)
+}
+
+// ReadBytes reads n bytes
+func (r *BitsReader) ReadBytes(n int) (bs []byte, err error) {
+	bs = make([]byte, n)
+	if r.cacheLen == 0 {
+		if err = r.read(bs); err != nil {
+			bs = nil
+		}
+		return
+	}
+	for i := range bs {
+		var b uint64
+		if b, err = r.readBitsN(8); err != nil {
+			bs = nil
+			return
+		}
+		bs[i] = byte(b)
+	}
+	return
+}
+
+// ReadExpGolomb reads an unsigned Exp-Golomb code, also known as ue(v)
+func (r *BitsReader) ReadExpGolomb() (o uint64, err error) {
+	n := 0
+	for {
+		var b uint64
+		if b, err = r.readBitsN(1); err != nil {
+			return
+		}
+		if b == 1 {
+			break
+		}
+		if n++; n > 63 {
+			err = errors.New("astikit: invalid Exp-Golomb code")
+			return
+		}
+	}
+	if o, err = r.readBitsN(n); err != nil {
+		return
+	}
+	o = (1<<n | o) - 1
+	return
+}
+
+// ReadSignedExpGolomb reads a signed Exp-Golomb code, also known as se(v)
+func (r *BitsReader) ReadSignedExpGolomb() (int64, error) {
+	o, err := r.ReadExpGolomb()
+	if err != nil {
+		return 0, err
+	}
+	if o&1 == 1 {
+		return int64(o>>1) + 1, nil
+	}
+	return -int64(o >> 1), nil
+}
+
+// ReadULEB128 reads an unsigned LEB128
+func (r *BitsReader) ReadULEB128() (o uint64, err error) {
+	for shift := 0; ; shift += 7 {
+		if shift >= 64 {
+			err = errors.New("astikit: LEB128 overflows 64 bits")
+			return
+		}
+		var b uint64
+		if b, err = r.readBitsN(8); err != nil {
+			return
+		}
+		// Only the lowest bit of the 10th byte's payload fits in 64 bits
+		if shift == 63 && b&0x7e != 0 {
+			err = errors.New("astikit: LEB128 overflows 64 bits")
+			return
+		}
+		o |= (b & 0x7f) << shift
+		if b&0x80 == 0 {
+			return
+		}
+	}
+}
+
+// ReadSLEB128 reads a signed LEB128
+func (r *BitsReader) ReadSLEB128() (o int64, err error) {
+	for shift := 0; ; shift += 7 {
+		if shift >= 64 {
+			err = errors.New("astikit: LEB128 overflows 64 bits")
+			return
+		}
+		var b uint64
+		if b, err = r.readBitsN(8); err != nil {
+			return
+		}
+		// The 10th byte's payload can only be a sign extension of its lowest bit
+		if shift == 63 && b&0x7f != 0 && b&0x7f != 0x7f {
+			err = errors.New("astikit: LEB128 overflows 64 bits")
+			return
+		}
+		o |= int64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			if shift+7 < 64 && b&0x40 != 0 {
+				o |= -1 << (shift + 7)
+			}
+			return
+		}
+	}
+}
+
+// ReadQUICVarint reads a QUIC variable-length integer (RFC 9000)
+func (r *BitsReader) ReadQUICVarint() (o uint64, err error) {
+	var p uint64
+	if p, err = r.readBitsN(2); err != nil {
+		return
+	}
+	return r.readBitsN(8<<p - 2)
+}
+
+// ReadExpandableSize reads an MPEG-4 descriptor length (ISO/IEC 14496-1 expandable
+// class size)
+func (r *BitsReader) ReadExpandableSize() (o uint32, err error) {
+	for i := 0; i < 4; i++ {
+		var b uint64
+		if b, err = r.readBitsN(8); err != nil {
+			return
+		}
+		o = o<<7 | uint32(b&0x7f)
+		if b&0x80 == 0 {
+			return
+		}
+	}
+	err = errors.New("astikit: expandable size is more than 4 bytes long")
+	return
+}
+
+// BitsReaderBatch allows to chain multiple Read* calls and check for error only once
+type BitsReaderBatch struct {
+	err error
+	r   *BitsReader
+}
+
+func NewBitsReaderBatch(r *BitsReader) BitsReaderBatch {
+	return BitsReaderBatch{
+		r: r,
+	}
+}
+
+// Calls BitsReader.ReadBit if there was no read error before
+func (b *BitsReaderBatch) ReadBit() (o uint8) {
+	if b.err == nil {
+		o, b.err = b.r.ReadBit()
+	}
+	return
+}
+
+// Calls BitsReader.ReadBool if there was no read error before
+func (b *BitsReaderBatch) ReadBool() (o bool) {
+	if b.err == nil {
+		o, b.err = b.r.ReadBool()
+	}
+	return
+}
+
+// Calls BitsReader.ReadN if there was no read error before
+func (b *BitsReaderBatch) ReadN(n int) (o uint64) {
+	if b.err == nil {
+		o, b.err = b.r.ReadN(n)
+	}
+	return
+}
+
+// Calls BitsReader.ReadUint8 if there was no read error before
+func (b *BitsReaderBatch) ReadUint8() (o uint8) {
+	if b.err == nil {
+		o, b.err = b.r.ReadUint8()
+	}
+	return
+}
+
+// Calls BitsReader.ReadUint16 if there was no read error before
+func (b *BitsReaderBatch) ReadUint16() (o uint16) {
+	if b.err == nil {
+		o, b.err = b.r.ReadUint16()
+	}
+	return
+}
+
+// Calls BitsReader.ReadUint32 if there was no read error before
+func (b *BitsReaderBatch) ReadUint32() (o uint32) {
+	if b.err == nil {
+		o, b.err = b.r.ReadUint32()
+	}
+	return
+}
+
+// Calls BitsReader.ReadUint64 if there was no read error before
+func (b *BitsReaderBatch) ReadUint64() (o uint64) {
+	if b.err == nil {
+		o, b.err = b.r.ReadUint64()
+	}
+	return
+}
+
+// Calls BitsReader.ReadBytes if there was no read error before
+func (b *BitsReaderBatch) ReadBytes(n int) (o []byte) {
+	if b.err == nil {
+		o, b.err = b.r.ReadBytes(n)
+	}
+	return
+}
+
+// Calls BitsReader.ReadExpGolomb if there was no read error before
+func (b *BitsReaderBatch) ReadExpGolomb() (o uint64) {
+	if b.err == nil {
+		o, b.err = b.r.ReadExpGolomb()
+	}
+	return
+}
+
+// Calls BitsReader.ReadSignedExpGolomb if there was no read error before
+func (b *BitsReaderBatch) ReadSignedExpGolomb() (o int64) {
+	if b.err == nil {
+		o, b.err = b.r.ReadSignedExpGolomb()
+	}
+	return
+}
+
+// Calls BitsReader.ReadULEB128 if there was no read error before
+func (b *BitsReaderBatch) ReadULEB128() (o uint64) {
+	if b.err == nil {
+		o, b.err = b.r.ReadULEB128()
+	}
+	return
+}
+
+// Calls BitsReader.ReadSLEB128 if there was no read error before
+func (b *BitsReaderBatch) ReadSLEB128() (o int64) {
+	if b.err == nil {
+		o, b.err = b.r.ReadSLEB128()
+	}
+	return
+}
+
+// Calls BitsReader.ReadQUICVarint if there was no read error before
+func (b *BitsReaderBatch) ReadQUICVarint() (o uint64) {
+	if b.err == nil {
+		o, b.err = b.r.ReadQUICVarint()
+	}
+	return
+}
+
+// Calls BitsReader.ReadExpandableSize if there was no read error before
+func (b *BitsReaderBatch) ReadExpandableSize() (o uint32) {
+	if b.err == nil {
+		o, b.err = b.r.ReadExpandableSize()
+	}
+	return
+}
+
+// Returns first read error
+func (b *BitsReaderBatch) Err() error {
+	return b.err
+}
+
 var byteHamming84Tab = [256]uint8{
 	0x01, 0xff, 0xff, 0x08, 0xff, 0x0c, 0x04, 0xff, 0xff, 0x08, 0x08, 0x08, 0x06, 0xff, 0xff, 0x08,
 	0xff, 0x0a, 0x02, 0xff, 0x06, 0xff, 0xff, 0x0f, 0x06, 0xff, 0xff, 0x08, 0x06, 0x06, 0x06, 0xff,
@@ -330,6 +1039,92 @@ func ByteHamming84Decode(i uint8) (o uint8, ok bool) {
 	return
 }
 
+var byteHamming84EncodeTab = [16]uint8{
+	0xa8, 0x40, 0x92, 0x7a, 0x26, 0xce, 0x1c, 0xf4, 0x0b, 0xe3, 0x31, 0xd9, 0x85, 0x6d, 0xbf, 0x57,
+}
+
+// ByteHamming84Encode hamming 8/4 encodes the 4 lowest bits of the input
+func ByteHamming84Encode(i uint8) uint8 {
+	return byteHamming84EncodeTab[i&0xf]
+}
+
+// byteHamming2418Tab contains, for each of the 3 bytes of a triplet, the data bits in
+// the 18 lowest bits and the parity checks in the 6 next bits
+var byteHamming2418Tab = newByteHamming2418Tab()
+
+// byteHamming2418ErrTab contains, for each syndrome, the data bits to flip or
+// byteHamming2418Invalid if the triplet can't be corrected
+var byteHamming2418ErrTab = newByteHamming2418ErrTab()
+
+const byteHamming2418Invalid = 0xffffffff
+
+// byteHamming2418DataBit returns the data bit index of a triplet bit position or -1 if
+// the position contains a parity bit. Positions start at 1.
+func byteHamming2418DataBit(pos int) int {
+	switch {
+	case pos == 3:
+		return 0
+	case pos >= 5 && pos <= 7:
+		return pos - 4
+	case pos >= 9 && pos <= 15:
+		return pos - 5
+	case pos >= 17 && pos <= 23:
+		return pos - 6
+	}
+	return -1
+}
+
+func newByteHamming2418Tab() (t [3][256]uint32) {
+	for idx := 0; idx < 3; idx++ {
+		for i := 0; i < 256; i++ {
+			for b := 0; b < 8; b++ {
+				if i>>b&0x1 == 0 {
+					continue
+				}
+				pos := idx*8 + b + 1
+				if d := byteHamming2418DataBit(pos); d >= 0 {
+					t[idx][i] |= 1 << d
+				}
+				if pos < 24 {
+					t[idx][i] ^= uint32(pos) << 18
+				}
+				t[idx][i] ^= 1 << 23
+			}
+		}
+	}
+	return
+}
+
+func newByteHamming2418ErrTab() (t [64]uint32) {
+	for s := 1; s < 64; s++ {
+		c := s & 0x1f
+		switch {
+		case s>>5 == 0 || c > 23:
+			t[s] = byteHamming2418Invalid
+		case c > 0:
+			if d := byteHamming2418DataBit(c); d >= 0 {
+				t[s] = 1 << d
+			}
+		}
+	}
+	return
+}
+
+// ByteHamming2418Decode hamming 24/18 decodes a triplet as defined in ETS 300 706.
+// The first transmitted byte must be in the 8 lowest bits of the input and the 18 data
+// bits are returned in the lowest bits of the output. Single bit errors are corrected
+// and double bit errors are detected, in which case ok is false.
+func ByteHamming2418Decode(i uint32) (o uint32, ok bool) {
+	v := byteHamming2418Tab[0][i&0xff] ^ byteHamming2418Tab[1][i>>8&0xff] ^ byteHamming2418Tab[2][i>>16&0xff]
+	e := byteHamming2418ErrTab[(v>>18)^0x3f]
+	if e == byteHamming2418Invalid {
+		return
+	}
+	o = (v ^ e) & 0x3ffff
+	ok = true
+	return
+}
+
 var byteParityTab = [256]uint8{
 	0x00, 0x01, 0x01, 0x00, 0x01, 0x00, 0x00, 0x01, 0x01, 0x00, 0x00, 0x01, 0x00, 0x01, 0x01, 0x00,
 	0x01, 0x00, 0x00, 0x01, 0x00, 0x01, 0x01, 0x00, 0x00, 0x01, 0x01, 0x00, 0x01, 0x00, 0x00, 0x01,
@@ -355,3 +1150,37 @@ func ByteParity(i uint8) (o uint8, ok bool) {
 	o = i & 0x7f
 	return
 }
+
+// ByteParityEncode sets the most significant bit of the input so that the output has an
+// odd parity
+func ByteParityEncode(i uint8) uint8 {
+	o := i & 0x7f
+	if byteParityTab[o] == 0 {
+		o |= 0x80
+	}
+	return o
+}
+
+var byteReverseTab = [256]uint8{
+	0x00, 0x80, 0x40, 0xc0, 0x20, 0xa0, 0x60, 0xe0, 0x10, 0x90, 0x50, 0xd0, 0x30, 0xb0, 0x70, 0xf0,
+	0x08, 0x88, 0x48, 0xc8, 0x28, 0xa8, 0x68, 0xe8, 0x18, 0x98, 0x58, 0xd8, 0x38, 0xb8, 0x78, 0xf8,
+	0x04, 0x84, 0x44, 0xc4, 0x24, 0xa4, 0x64, 0xe4, 0x14, 0x94, 0x54, 0xd4, 0x34, 0xb4, 0x74, 0xf4,
+	0x0c, 0x8c, 0x4c, 0xcc, 0x2c, 0xac, 0x6c, 0xec, 0x1c, 0x9c, 0x5c, 0xdc, 0x3c, 0xbc, 0x7c, 0xfc,
+	0x02, 0x82, 0x42, 0xc2, 0x22, 0xa2, 0x62, 0xe2, 0x12, 0x92, 0x52, 0xd2, 0x32, 0xb2, 0x72, 0xf2,
+	0x0a, 0x8a, 0x4a, 0xca, 0x2a, 0xaa, 0x6a, 0xea, 0x1a, 0x9a, 0x5a, 0xda, 0x3a, 0xba, 0x7a, 0xfa,
+	0x06, 0x86, 0x46, 0xc6, 0x26, 0xa6, 0x66, 0xe6, 0x16, 0x96, 0x56, 0xd6, 0x36, 0xb6, 0x76, 0xf6,
+	0x0e, 0x8e, 0x4e, 0xce, 0x2e, 0xae, 0x6e, 0xee, 0x1e, 0x9e, 0x5e, 0xde, 0x3e, 0xbe, 0x7e, 0xfe,
+	0x01, 0x81, 0x41, 0xc1, 0x21, 0xa1, 0x61, 0xe1, 0x11, 0x91, 0x51, 0xd1, 0x31, 0xb1, 0x71, 0xf1,
+	0x09, 0x89, 0x49, 0xc9, 0x29, 0xa9, 0x69, 0xe9, 0x19, 0x99, 0x59, 0xd9, 0x39, 0xb9, 0x79, 0xf9,
+	0x05, 0x85, 0x45, 0xc5, 0x25, 0xa5, 0x65, 0xe5, 0x15, 0x95, 0x55, 0xd5, 0x35, 0xb5, 0x75, 0xf5,
+	0x0d, 0x8d, 0x4d, 0xcd, 0x2d, 0xad, 0x6d, 0xed, 0x1d, 0x9d, 0x5d, 0xdd, 0x3d, 0xbd, 0x7d, 0xfd,
+	0x03, 0x83, 0x43, 0xc3, 0x23, 0xa3, 0x63, 0xe3, 0x13, 0x93, 0x53, 0xd3, 0x33, 0xb3, 0x73, 0xf3,
+	0x0b, 0x8b, 0x4b, 0xcb, 0x2b, 0xab, 0x6b, 0xeb, 0x1b, 0x9b, 0x5b, 0xdb, 0x3b, 0xbb, 0x7b, 0xfb,
+	0x07, 0x87, 0x47, 0xc7, 0x27, 0xa7, 0x67, 0xe7, 0x17, 0x97, 0x57, 0xd7, 0x37, 0xb7, 0x77, 0xf7,
+	0x0f, 0x8f, 0x4f, 0xcf, 0x2f, 0xaf, 0x6f, 0xef, 0x1f, 0x9f, 0x5f, 0xdf, 0x3f, 0xbf, 0x7f, 0xff,
+}
+
+// ByteReverse reverses the bits order of the input
+func ByteReverse(i uint8) uint8 {
+	return byteReverseTab[i]
+}
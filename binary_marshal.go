@@ -0,0 +1,513 @@
+package astikit
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// BitsMarshaler represents an object that can marshal itself into a BitsWriter
+type BitsMarshaler interface {
+	MarshalBits(w *BitsWriter) error
+}
+
+// BitsUnmarshaler represents an object that can unmarshal itself from a BitsReader
+type BitsUnmarshaler interface {
+	UnmarshalBits(r *BitsReader) error
+}
+
+var (
+	bitsMarshalerType   = reflect.TypeOf((*BitsMarshaler)(nil)).Elem()
+	bitsUnmarshalerType = reflect.TypeOf((*BitsUnmarshaler)(nil)).Elem()
+)
+
+// bitsTag represents a parsed "bits" struct tag
+type bitsTag struct {
+	byteOrder binary.ByteOrder
+	cond      string
+	len       int
+	lenField  string
+	n         int
+	prefix    int
+	reserved  string
+}
+
+// parseBitsTag parses a "bits" struct tag which has the following format:
+//
+//	bits:"[n][,option]..."
+//
+// n is the number of bits of the field or, for slices and arrays, of each item. When
+// omitted, it defaults to the size of the type. Available options are:
+//   - reserved=1111: the field value is ignored and the provided bits are written instead.
+//     They are skipped when unmarshaling. n defaults to the number of provided bits.
+//   - len=4 or len=Field: the slice has either a fixed number of items or the number of
+//     items stored in a previous field
+//   - prefix=8: the number of items of the slice is written in the provided number of bits
+//     right before the items
+//   - if=Field: the field is only processed if the previous field is not a zero value
+//   - le or be: the integer is processed with the little or big endian byte order, n must
+//     be a multiple of 8. By default bits are processed from left to right.
+func parseBitsTag(tag string) (t bitsTag, err error) {
+	t.n = -1
+	for idx, s := range strings.Split(tag, ",") {
+		s = strings.TrimSpace(s)
+		if idx == 0 {
+			if s != "" {
+				if t.n, err = strconv.Atoi(s); err != nil || t.n <= 0 || t.n > 64 {
+					err = fmt.Errorf("astikit: invalid number of bits %s", s)
+					return
+				}
+			}
+			continue
+		}
+
+		k, v, _ := strings.Cut(s, "=")
+		switch k {
+		case "be":
+			t.byteOrder = binary.BigEndian
+		case "if":
+			t.cond = v
+		case "le":
+			t.byteOrder = binary.LittleEndian
+		case "len":
+			if i, errAtoi := strconv.Atoi(v); errAtoi == nil {
+				t.len = i
+			} else {
+				t.lenField = v
+			}
+		case "prefix":
+			if t.prefix, err = strconv.Atoi(v); err != nil || t.prefix <= 0 || t.prefix > 64 {
+				err = fmt.Errorf("astikit: invalid prefix %s", v)
+				return
+			}
+		case "reserved":
+			if strings.Trim(v, "01") != "" || v == "" {
+				err = fmt.Errorf("astikit: invalid reserved bits %s", v)
+				return
+			}
+			t.reserved = v
+			if t.n < 0 {
+				t.n = len(v)
+			}
+		default:
+			err = fmt.Errorf("astikit: unknown option %s", k)
+			return
+		}
+	}
+	return
+}
+
+func (t bitsTag) bits(k reflect.Kind) (n int, err error) {
+	n = t.n
+	if n < 0 {
+		switch k {
+		case reflect.Bool:
+			n = 1
+		case reflect.Uint8, reflect.Int8:
+			n = 8
+		case reflect.Uint16, reflect.Int16:
+			n = 16
+		case reflect.Uint32, reflect.Int32:
+			n = 32
+		case reflect.Uint64, reflect.Int64:
+			n = 64
+		default:
+			err = fmt.Errorf("astikit: number of bits is mandatory for kind %s", k)
+			return
+		}
+	}
+	if t.byteOrder != nil && n%8 != 0 {
+		err = fmt.Errorf("astikit: number of bits %d must be a multiple of 8 when using a byte order", n)
+		return
+	}
+	return
+}
+
+func bitsStructField(s reflect.Value, name string) (reflect.Value, error) {
+	f := s.FieldByName(name)
+	if !f.IsValid() {
+		return f, fmt.Errorf("astikit: unknown field %s", name)
+	}
+	return f, nil
+}
+
+func bitsStructFieldInt(s reflect.Value, name string) (int, error) {
+	f, err := bitsStructField(s, name)
+	if err != nil {
+		return 0, err
+	}
+	switch f.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(f.Uint()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(f.Int()), nil
+	}
+	return 0, fmt.Errorf("astikit: field %s is not an integer", name)
+}
+
+func bitsStructFieldIsSet(s reflect.Value, name string) (bool, error) {
+	f, err := bitsStructField(s, name)
+	if err != nil {
+		return false, err
+	}
+	return !f.IsZero(), nil
+}
+
+// MarshalBits writes a struct into a BitsWriter based on its "bits" struct tags. Fields
+// without a "bits" struct tag are ignored. Check out parseBitsTag for the tag format.
+// Fields implementing BitsMarshaler are marshaled using their MarshalBits method.
+func MarshalBits(w *BitsWriter, v any) error {
+	// Make sure the value is addressable so that BitsMarshaler methods with a pointer
+	// receiver are found
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return errors.New("astikit: v must not be nil")
+	} else if rv.Kind() != reflect.Ptr {
+		p := reflect.New(rv.Type())
+		p.Elem().Set(rv)
+		rv = p
+	}
+	return marshalBitsValue(w, rv, bitsTag{n: -1})
+}
+
+func marshalBitsStruct(w *BitsWriter, s reflect.Value) error {
+	for idx := 0; idx < s.NumField(); idx++ {
+		// Get tag
+		sf := s.Type().Field(idx)
+		tag, ok := sf.Tag.Lookup("bits")
+		if !ok {
+			continue
+		}
+
+		// Parse tag
+		t, err := parseBitsTag(tag)
+		if err != nil {
+			return fmt.Errorf("astikit: parsing tag of field %s failed: %w", sf.Name, err)
+		}
+
+		// Condition
+		if t.cond != "" {
+			if ok, err = bitsStructFieldIsSet(s, t.cond); err != nil {
+				return fmt.Errorf("astikit: checking condition of field %s failed: %w", sf.Name, err)
+			} else if !ok {
+				continue
+			}
+		}
+
+		// Reserved
+		if t.reserved != "" {
+			if err = marshalBitsReserved(w, t); err != nil {
+				return fmt.Errorf("astikit: marshaling reserved field %s failed: %w", sf.Name, err)
+			}
+			continue
+		}
+
+		// Unexported
+		if !sf.IsExported() {
+			return fmt.Errorf("astikit: field %s is unexported", sf.Name)
+		}
+
+		// Number of items
+		f := s.Field(idx)
+		if t.lenField != "" {
+			if t.len, err = bitsStructFieldInt(s, t.lenField); err != nil {
+				return fmt.Errorf("astikit: getting length of field %s failed: %w", sf.Name, err)
+			}
+			if f.Kind() == reflect.Slice && f.Len() != t.len {
+				return fmt.Errorf("astikit: field %s has %d items, %s says %d", sf.Name, f.Len(), t.lenField, t.len)
+			}
+		}
+
+		// Marshal
+		if err = marshalBitsValue(w, f, t); err != nil {
+			return fmt.Errorf("astikit: marshaling field %s failed: %w", sf.Name, err)
+		}
+	}
+	return nil
+}
+
+func marshalBitsReserved(w *BitsWriter, t bitsTag) error {
+	// Pad with the last bit if n is bigger than the provided bits
+	s := t.reserved
+	if len(s) < t.n {
+		s += strings.Repeat(s[len(s)-1:], t.n-len(s))
+	}
+	return w.Write(s[:t.n])
+}
+
+func marshalBitsValue(w *BitsWriter, v reflect.Value, t bitsTag) (err error) {
+	// Custom marshaler
+	if v.Type().Implements(bitsMarshalerType) {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return errors.New("astikit: nil pointer")
+		}
+		return v.Interface().(BitsMarshaler).MarshalBits(w)
+	} else if v.CanAddr() && v.Addr().Type().Implements(bitsMarshalerType) {
+		return v.Addr().Interface().(BitsMarshaler).MarshalBits(w)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return errors.New("astikit: nil pointer")
+		}
+		return marshalBitsValue(w, v.Elem(), t)
+	case reflect.Struct:
+		return marshalBitsStruct(w, v)
+	case reflect.Bool:
+		var n int
+		if n, err = t.bits(v.Kind()); err != nil {
+			return
+		}
+		var i uint64
+		if v.Bool() {
+			i = 1
+		}
+		return w.WriteN(i, n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n int
+		if n, err = t.bits(v.Kind()); err != nil {
+			return
+		}
+		return marshalBitsUint(w, v.Uint(), n, t.byteOrder, v.Interface())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int
+		if n, err = t.bits(v.Kind()); err != nil {
+			return
+		}
+		i := v.Int()
+		if n < 64 && (i < -(1<<(n-1)) || i >= 1<<(n-1)) {
+			return BitsWriterOverflowError{N: n, Value: v.Interface()}
+		}
+		return marshalBitsUint(w, uint64(i)&(^uint64(0)>>(64-n)), n, t.byteOrder, v.Interface())
+	case reflect.Slice, reflect.Array:
+		// Fixed length
+		if t.len > 0 && v.Len() != t.len {
+			return fmt.Errorf("astikit: %d items found, %d expected", v.Len(), t.len)
+		}
+
+		// Prefix
+		if t.prefix > 0 {
+			if err = marshalBitsUint(w, uint64(v.Len()), t.prefix, nil, v.Len()); err != nil {
+				return fmt.Errorf("astikit: marshaling prefix failed: %w", err)
+			}
+		}
+
+		// Loop through items
+		it := t
+		it.len, it.lenField, it.prefix = 0, "", 0
+		for idx := 0; idx < v.Len(); idx++ {
+			if err = marshalBitsValue(w, v.Index(idx), it); err != nil {
+				return fmt.Errorf("astikit: marshaling item #%d failed: %w", idx, err)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("astikit: invalid kind %s", v.Kind())
+}
+
+func marshalBitsUint(w *BitsWriter, i uint64, n int, bo binary.ByteOrder, v any) error {
+	if n < 64 && i>>n != 0 {
+		return BitsWriterOverflowError{N: n, Value: v}
+	}
+	if bo != binary.LittleEndian {
+		return w.writeBitsN(i, n)
+	}
+	for idx := 0; idx < n/8; idx++ {
+		if err := w.writeBitsN(i>>(idx*8), 8); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnmarshalBits reads a struct from a BitsReader based on its "bits" struct tags. v must
+// be a pointer. Check out MarshalBits for more information.
+// Fields implementing BitsUnmarshaler are unmarshaled using their UnmarshalBits method.
+func UnmarshalBits(r *BitsReader, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("astikit: v must be a non-nil pointer")
+	}
+	return unmarshalBitsValue(r, rv.Elem(), bitsTag{n: -1})
+}
+
+func unmarshalBitsStruct(r *BitsReader, s reflect.Value) error {
+	for idx := 0; idx < s.NumField(); idx++ {
+		// Get tag
+		sf := s.Type().Field(idx)
+		tag, ok := sf.Tag.Lookup("bits")
+		if !ok {
+			continue
+		}
+
+		// Parse tag
+		t, err := parseBitsTag(tag)
+		if err != nil {
+			return fmt.Errorf("astikit: parsing tag of field %s failed: %w", sf.Name, err)
+		}
+
+		// Condition
+		if t.cond != "" {
+			if ok, err = bitsStructFieldIsSet(s, t.cond); err != nil {
+				return fmt.Errorf("astikit: checking condition of field %s failed: %w", sf.Name, err)
+			} else if !ok {
+				continue
+			}
+		}
+
+		// Reserved
+		if t.reserved != "" {
+			if _, err = r.ReadN(t.n); err != nil {
+				return fmt.Errorf("astikit: unmarshaling reserved field %s failed: %w", sf.Name, err)
+			}
+			continue
+		}
+
+		// Unexported
+		if !sf.IsExported() {
+			return fmt.Errorf("astikit: field %s is unexported", sf.Name)
+		}
+
+		// Number of items
+		if t.lenField != "" {
+			if t.len, err = bitsStructFieldInt(s, t.lenField); err != nil {
+				return fmt.Errorf("astikit: getting length of field %s failed: %w", sf.Name, err)
+			}
+		}
+
+		// Unmarshal
+		if err = unmarshalBitsValue(r, s.Field(idx), t); err != nil {
+			return fmt.Errorf("astikit: unmarshaling field %s failed: %w", sf.Name, err)
+		}
+	}
+	return nil
+}
+
+func unmarshalBitsValue(r *BitsReader, v reflect.Value, t bitsTag) (err error) {
+	// Custom unmarshaler
+	if v.CanAddr() && v.Addr().Type().Implements(bitsUnmarshalerType) {
+		return v.Addr().Interface().(BitsUnmarshaler).UnmarshalBits(r)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return unmarshalBitsValue(r, v.Elem(), t)
+	case reflect.Struct:
+		return unmarshalBitsStruct(r, v)
+	case reflect.Bool:
+		var n int
+		if n, err = t.bits(v.Kind()); err != nil {
+			return
+		}
+		var i uint64
+		if i, err = r.ReadN(n); err != nil {
+			return
+		}
+		v.SetBool(i > 0)
+		return
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n int
+		if n, err = t.bits(v.Kind()); err != nil {
+			return
+		}
+		var i uint64
+		if i, err = unmarshalBitsUint(r, n, t.byteOrder); err != nil {
+			return
+		}
+		if v.OverflowUint(i) {
+			return fmt.Errorf("astikit: %d overflows kind %s", i, v.Kind())
+		}
+		v.SetUint(i)
+		return
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int
+		if n, err = t.bits(v.Kind()); err != nil {
+			return
+		}
+		var u uint64
+		if u, err = unmarshalBitsUint(r, n, t.byteOrder); err != nil {
+			return
+		}
+
+		// Sign extend
+		i := int64(u)
+		if n > 0 && n < 64 && u>>(n-1)&0x1 > 0 {
+			i = int64(u | ^uint64(0)<<n)
+		}
+		if v.OverflowInt(i) {
+			return fmt.Errorf("astikit: %d overflows kind %s", i, v.Kind())
+		}
+		v.SetInt(i)
+		return
+	case reflect.Slice, reflect.Array:
+		// Get number of items
+		l := t.len
+		if t.prefix > 0 {
+			var i uint64
+			if i, err = r.ReadN(t.prefix); err != nil {
+				return fmt.Errorf("astikit: unmarshaling prefix failed: %w", err)
+			}
+			l = int(i)
+		}
+		it := t
+		it.len, it.lenField, it.prefix = 0, "", 0
+		if v.Kind() == reflect.Array {
+			if l > 0 && l != v.Len() {
+				return fmt.Errorf("astikit: %d items found, %d expected", l, v.Len())
+			}
+			l = v.Len()
+		} else if l > 0 {
+			// Make sure a malformed number of items can't force a huge allocation: check it
+			// against the number of bits left when known, and grow the slice progressively
+			// otherwise
+			c := l
+			if left, ok := r.bitsLeft(); ok {
+				ib, errB := it.bits(v.Type().Elem().Kind())
+				if errB != nil {
+					ib = 1
+				}
+				if l > left/ib {
+					return fmt.Errorf("astikit: %d items of at least %d bits don't fit in the %d bits left", l, ib, left)
+				}
+			} else if c > 1024 {
+				c = 1024
+			}
+			v.Set(reflect.MakeSlice(v.Type(), 0, c))
+		} else {
+			v.Set(reflect.Zero(v.Type()))
+		}
+
+		// Loop through items
+		for idx := 0; idx < l; idx++ {
+			if v.Kind() == reflect.Slice {
+				v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+			}
+			if err = unmarshalBitsValue(r, v.Index(idx), it); err != nil {
+				return fmt.Errorf("astikit: unmarshaling item #%d failed: %w", idx, err)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("astikit: invalid kind %s", v.Kind())
+}
+
+func unmarshalBitsUint(r *BitsReader, n int, bo binary.ByteOrder) (i uint64, err error) {
+	if bo != binary.LittleEndian {
+		return r.ReadN(n)
+	}
+	for idx := 0; idx < n/8; idx++ {
+		var b uint64
+		if b, err = r.readBitsN(8); err != nil {
+			return
+		}
+		i |= b << (idx * 8)
+	}
+	return
+}
@@ -0,0 +1,199 @@
+package astikit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type testBitsDescriptor struct {
+	Tag    uint8  `bits:""`
+	Length uint8  `bits:""`
+	Data   []byte `bits:",len=Length"`
+}
+
+type testBitsCustom struct {
+	v uint8
+}
+
+func (c *testBitsCustom) MarshalBits(w *BitsWriter) error {
+	return w.WriteN(c.v, 4)
+}
+
+func (c *testBitsCustom) UnmarshalBits(r *BitsReader) (err error) {
+	var i uint64
+	if i, err = r.ReadN(4); err != nil {
+		return
+	}
+	c.v = uint8(i)
+	return
+}
+
+type testBitsSection struct {
+	TableID     uint8                `bits:""`
+	HasSyntax   bool                 `bits:""`
+	_           uint8                `bits:"3,reserved=011"`
+	Length      uint16               `bits:"12"`
+	Offset      int8                 `bits:"5"`
+	Gain        int16                `bits:"11"`
+	Extension   *testBitsDescriptor  `bits:",if=HasSyntax"`
+	Version     uint32               `bits:"24,le"`
+	Flags       [3]bool              `bits:""`
+	_           struct{}             `bits:"5,reserved=1"`
+	Descriptors []testBitsDescriptor `bits:",prefix=4"`
+	Custom      testBitsCustom       `bits:""`
+	PIDs        []uint16             `bits:"12,len=2"`
+	Ignored     string
+}
+
+func TestMarshalBits(t *testing.T) {
+	v := testBitsSection{
+		TableID:   2,
+		HasSyntax: true,
+		Length:    0xabc,
+		Offset:    -3,
+		Gain:      1000,
+		Extension: &testBitsDescriptor{
+			Tag:    0x0a,
+			Length: 2,
+			Data:   []byte("fr"),
+		},
+		Version: 0x010203,
+		Flags:   [3]bool{true, false, true},
+		Descriptors: []testBitsDescriptor{
+			{Tag: 1, Length: 1, Data: []byte{0xff}},
+			{Tag: 2},
+		},
+		Custom:  testBitsCustom{v: 0x9},
+		PIDs:    []uint16{0x100, 0xfff},
+		Ignored: "ignored",
+	}
+
+	buf := &bytes.Buffer{}
+	w := NewBitsWriter(BitsWriterOptions{Writer: buf})
+	if err := MarshalBits(w, &v); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if !w.IsAligned() {
+		t.Fatal("expected true, got false")
+	}
+
+	e := &bytes.Buffer{}
+	ew := NewBitsWriterBatch(NewBitsWriter(BitsWriterOptions{Writer: e}))
+	ew.Annotate("TableID")
+	ew.Write(uint8(2))
+	ew.Annotate("HasSyntax")
+	ew.Write("1011")
+	ew.Annotate("Length")
+	ew.WriteN(uint16(0xabc), 12)
+	ew.Annotate("Offset")
+	ew.Write("11101")
+	ew.Annotate("Gain")
+	ew.Write("01111101000")
+	ew.Annotate("Extension")
+	ew.Write([]byte{0x0a, 0x02, 'f', 'r'})
+	ew.Annotate("Version")
+	ew.Write([]byte{0x03, 0x02, 0x01})
+	ew.Annotate("Flags")
+	ew.Write("101")
+	ew.Write("11111")
+	ew.Annotate("Descriptors")
+	ew.Write("0010")
+	ew.Write([]byte{0x01, 0x01, 0xff, 0x02, 0x00})
+	ew.Annotate("Custom")
+	ew.Write("1001")
+	ew.Annotate("PIDs")
+	ew.WriteN(uint16(0x100), 12)
+	ew.WriteN(uint16(0xfff), 12)
+	if err := ew.Err(); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if d := BytesDiff(e.Bytes(), buf.Bytes(), ew.Annotations()...); d != "" {
+		t.Fatalf("unexpected bytes:\n%s", d)
+	}
+
+	var g testBitsSection
+	if err := UnmarshalBits(NewBitsReaderFromBytes(buf.Bytes(), BitsReaderOptions{}), &g); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	v.Ignored = ""
+	if !reflect.DeepEqual(v, g) {
+		t.Fatalf("expected %+v, got %+v", v, g)
+	}
+
+	// Condition
+	v.HasSyntax = false
+	buf.Reset()
+	if err := MarshalBits(w, v); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	g = testBitsSection{}
+	if err := UnmarshalBits(NewBitsReaderFromBytes(buf.Bytes(), BitsReaderOptions{}), &g); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if g.Extension != nil {
+		t.Fatalf("expected nil, got %+v", g.Extension)
+	}
+	if e, g := v.PIDs, g.PIDs; !reflect.DeepEqual(e, g) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+
+	// Errors
+	v.Offset = 16
+	var oe BitsWriterOverflowError
+	if err := MarshalBits(w, v); !errors.As(err, &oe) {
+		t.Fatalf("expected overflow error, got %+v", err)
+	}
+	v.Offset = 0
+	v.PIDs = []uint16{1}
+	if err := MarshalBits(w, v); err == nil {
+		t.Fatal("expected error")
+	}
+	v.PIDs = []uint16{1, 2}
+	v.Descriptors = []testBitsDescriptor{{Length: 1}}
+	if err := MarshalBits(w, v); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := MarshalBits(w, struct {
+		A uint8 `bits:"4,unknown"`
+	}{}); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := MarshalBits(w, struct {
+		A uint16 `bits:"12,le"`
+	}{}); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := MarshalBits(w, struct {
+		a uint8 `bits:""`
+	}{}); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := MarshalBits(w, struct {
+		A int8 `bits:"0"`
+	}{}); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := MarshalBits(w, struct {
+		A uint64 `bits:"65"`
+	}{}); err == nil {
+		t.Fatal("expected error")
+	}
+	var p struct {
+		A []uint8 `bits:",prefix=32"`
+	}
+	if err := UnmarshalBits(NewBitsReaderFromBytes([]byte{0xff, 0xff, 0xff, 0xff, 0x01}, BitsReaderOptions{}), &p); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := UnmarshalBits(NewBitsReader(BitsReaderOptions{Reader: io.MultiReader(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, 0x01}))}), &p); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := UnmarshalBits(NewBitsReaderFromBytes(nil, BitsReaderOptions{}), g); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := UnmarshalBits(NewBitsReaderFromBytes([]byte{0x01}, BitsReaderOptions{}), &g); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -2,8 +2,11 @@ package astikit
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
 	"testing"
 )
@@ -94,6 +97,127 @@ func TestBitsWriter(t *testing.T) {
 	}
 }
 
+func TestBitsWriter_WriteNOverflow(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewBitsWriter(BitsWriterOptions{Writer: buf})
+	b := NewBitsWriterBatch(w)
+	b.WriteN(int8(-1), 4)
+	b.WriteN(int16(7), 4)
+	b.WriteN(int32(-8), 4)
+	b.WriteN(int64(-2), 12)
+	b.WriteN(int64(-1), 64)
+	b.WriteN(uint(3), 2)
+	b.WriteN(uint8(0xff), 6)
+	b.Write(int8(-2))
+	b.Write(int16(-2))
+	b.Write(int32(1))
+	b.Write(int64(-1))
+	if err := b.Err(); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e, g := []byte{
+		0xf7, 0x8f, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe, 0xff, 0xfe, 0x0, 0x0,
+		0x0, 0x1, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	}, buf.Bytes(); !bytes.Equal(e, g) {
+		t.Fatalf("expected %x, got %x", e, g)
+	}
+
+	for _, v := range []struct {
+		i any
+		n int
+	}{
+		{i: int8(8), n: 4},
+		{i: int8(-9), n: 4},
+		{i: int16(1), n: 0},
+		{i: int32(math.MaxInt32), n: 31},
+		{i: int64(math.MinInt64), n: 63},
+		{i: int8(-2), n: 1},
+		{i: uint(4), n: 2},
+	} {
+		err := w.WriteN(v.i, v.n)
+		var oe BitsWriterOverflowError
+		if !errors.As(err, &oe) {
+			t.Fatalf("%v/%d: expected overflow error, got %+v", v.i, v.n, err)
+		}
+		if e := (BitsWriterOverflowError{N: v.n, Value: v.i}); !reflect.DeepEqual(e, oe) {
+			t.Fatalf("expected %+v, got %+v", e, oe)
+		}
+	}
+
+	w = NewBitsWriter(BitsWriterOptions{
+		CheckOverflow: true,
+		Writer:        &bytes.Buffer{},
+	})
+	if err := w.WriteN(uint8(0xff), 8); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if err := w.WriteN(uint64(math.MaxUint64), 64); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if err := w.WriteN(uint8(0xff), 7); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := w.WriteN(uint32(0x10000), 16); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestBitsWriter_Alignment(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewBitsWriter(BitsWriterOptions{
+		FlushPattern: 0x0f,
+		Writer:       buf,
+	})
+	if !w.IsAligned() {
+		t.Fatal("expected true, got false")
+	}
+	if err := w.Write("101"); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e, g := uint64(3), w.BitsWritten(); e != g {
+		t.Fatalf("expected %d, got %d", e, g)
+	}
+	if w.IsAligned() {
+		t.Fatal("expected false, got true")
+	}
+	if err := w.WriteAlignPadding(0xff); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if !w.IsAligned() {
+		t.Fatal("expected true, got false")
+	}
+	if e, g := uint64(8), w.BitsWritten(); e != g {
+		t.Fatalf("expected %d, got %d", e, g)
+	}
+	if err := w.WriteAlignPadding(0xff); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e, g := uint64(8), w.BitsWritten(); e != g {
+		t.Fatalf("expected %d, got %d", e, g)
+	}
+	if err := w.WriteN(uint16(0), 10); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if err := w.WriteAlignPadding(0xaa); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if err := w.Write("1"); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e, g := uint64(25), w.BitsWritten(); e != g {
+		t.Fatalf("expected %d, got %d", e, g)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e, g := uint64(32), w.BitsWritten(); e != g {
+		t.Fatalf("expected %d, got %d", e, g)
+	}
+	if e, g := []byte{0xbf, 0x00, 0x2a, 0x8f}, buf.Bytes(); !bytes.Equal(e, g) {
+		t.Fatalf("expected %x, got %x", e, g)
+	}
+}
+
 var bitsWriter_WriteBytesN_testCases = []struct {
 	bs       []byte
 	n        int
@@ -239,6 +363,366 @@ func BenchmarkBitsWriter_WriteBytesN(b *testing.B) {
 	}
 }
 
+func TestBitsReader(t *testing.T) {
+	cbBuf := bytes.Buffer{}
+	r := NewBitsReaderFromBytes([]byte{0x5, 0x2, 0x3, 0x4, 0x0, 0x5, 0x0, 0x0, 0x0, 0x6, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x7, 0x90, 0x0}, BitsReaderOptions{
+		ReadCallback: func(i []byte) {
+			cbBuf.Write(i)
+		},
+	})
+
+	n, err := r.ReadN(5)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e := uint64(0); e != n {
+		t.Fatalf("expected %d, got %d", e, n)
+	}
+	b, err := r.ReadBool()
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if !b {
+		t.Fatal("expected true, got false")
+	}
+	bit, err := r.ReadBit()
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e := uint8(0); e != bit {
+		t.Fatalf("expected %d, got %d", e, bit)
+	}
+	bit, err = r.ReadBit()
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e := uint8(1); e != bit {
+		t.Fatalf("expected %d, got %d", e, bit)
+	}
+	bs, err := r.ReadBytes(2)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e := []byte{2, 3}; !reflect.DeepEqual(e, bs) {
+		t.Fatalf("expected %+v, got %+v", e, bs)
+	}
+	u8, err := r.ReadUint8()
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e := uint8(4); e != u8 {
+		t.Fatalf("expected %d, got %d", e, u8)
+	}
+	u16, err := r.ReadUint16()
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e := uint16(5); e != u16 {
+		t.Fatalf("expected %d, got %d", e, u16)
+	}
+	u32, err := r.ReadUint32()
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e := uint32(6); e != u32 {
+		t.Fatalf("expected %d, got %d", e, u32)
+	}
+	u64, err := r.ReadUint64()
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e := uint64(7); e != u64 {
+		t.Fatalf("expected %d, got %d", e, u64)
+	}
+	n, err = r.ReadN(3)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e := uint64(4); e != n {
+		t.Fatalf("expected %d, got %d", e, n)
+	}
+	n, err = r.ReadN(13)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e := uint64(4096); e != n {
+		t.Fatalf("expected %d, got %d", e, n)
+	}
+	if e, g := []byte{0x5, 0x2, 0x3, 0x4, 0x0, 0x5, 0x0, 0x0, 0x0, 0x6, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x7, 0x90, 0x0}, cbBuf.Bytes(); !reflect.DeepEqual(e, g) {
+		t.Fatalf("callback buffer: expected %+v, got %+v", e, g)
+	}
+	if _, err = r.ReadBit(); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err = r.ReadN(65); err == nil {
+		t.Fatal("expected error")
+	}
+
+	r = NewBitsReaderFromBytes([]byte{0xf1, 0x23, 0x45, 0x67}, BitsReaderOptions{ByteOrder: binary.LittleEndian})
+	if _, err = r.ReadN(4); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	u16, err = r.ReadUint16()
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e := uint16(0x3412); e != u16 {
+		t.Fatalf("expected %x, got %x", e, u16)
+	}
+	bs, err = r.ReadBytes(1)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e := []byte{0x56}; !reflect.DeepEqual(e, bs) {
+		t.Fatalf("expected %+v, got %+v", e, bs)
+	}
+}
+
+func TestBitsReaderRoundTrip(t *testing.T) {
+	for _, bo := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		buf := &bytes.Buffer{}
+		w := NewBitsWriter(BitsWriterOptions{
+			ByteOrder: bo,
+			Writer:    buf,
+		})
+		wb := NewBitsWriterBatch(w)
+		wb.Write("101")
+		wb.Write(uint16(0xabcd))
+		wb.WriteN(uint64(0x1ffffffff), 33)
+		wb.Write(uint32(0xdeadbeef))
+		wb.Write(uint64(0x0102030405060708))
+		wb.Write([]byte{1, 2})
+		wb.Write(true)
+		wb.WriteN(uint8(0), 3)
+		if err := wb.Err(); err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+
+		r := NewBitsReader(BitsReaderOptions{
+			ByteOrder: bo,
+			Reader:    buf,
+		})
+		rb := NewBitsReaderBatch(r)
+		if e, g := uint64(5), rb.ReadN(3); e != g {
+			t.Fatalf("expected %x, got %x", e, g)
+		}
+		if e, g := uint16(0xabcd), rb.ReadUint16(); e != g {
+			t.Fatalf("expected %x, got %x", e, g)
+		}
+		if e, g := uint64(0x1ffffffff), rb.ReadN(33); e != g {
+			t.Fatalf("expected %x, got %x", e, g)
+		}
+		if e, g := uint32(0xdeadbeef), rb.ReadUint32(); e != g {
+			t.Fatalf("expected %x, got %x", e, g)
+		}
+		if e, g := uint64(0x0102030405060708), rb.ReadUint64(); e != g {
+			t.Fatalf("expected %x, got %x", e, g)
+		}
+		if e, g := []byte{1, 2}, rb.ReadBytes(2); !reflect.DeepEqual(e, g) {
+			t.Fatalf("expected %+v, got %+v", e, g)
+		}
+		if !rb.ReadBool() {
+			t.Fatal("expected true, got false")
+		}
+		if e, g := uint8(0), rb.ReadBit(); e != g {
+			t.Fatalf("expected %x, got %x", e, g)
+		}
+		if e, g := uint8(0), uint8(rb.ReadN(2)); e != g {
+			t.Fatalf("expected %x, got %x", e, g)
+		}
+		if err := rb.Err(); err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+
+		// let's check if the error is persisted
+		rb.ReadUint8()
+		if err := rb.Err(); err == nil {
+			t.Fatal("expected error")
+		}
+		rb.ReadBytes(0)
+		if err := rb.Err(); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+}
+
+func TestBitsVariableLengthCodes(t *testing.T) {
+	for _, v := range []struct {
+		bits string
+		u    uint64
+	}{
+		{bits: "1", u: 0},
+		{bits: "010", u: 1},
+		{bits: "011", u: 2},
+		{bits: "00100", u: 3},
+		{bits: "00111", u: 6},
+		{bits: "0001000", u: 7},
+		{bits: "000010001", u: 16},
+	} {
+		buf := &bytes.Buffer{}
+		w := NewBitsWriter(BitsWriterOptions{Writer: buf})
+		if err := w.WriteExpGolomb(v.u); err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+		if err := w.Write(v.bits); err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+		if err := w.WriteN(uint8(0), (8-2*len(v.bits)%8)%8); err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+		r := NewBitsReader(BitsReaderOptions{Reader: bytes.NewReader(buf.Bytes())})
+		for i := 0; i < 2; i++ {
+			g, err := r.ReadExpGolomb()
+			if err != nil {
+				t.Fatalf("expected no error, got %+v", err)
+			}
+			if g != v.u {
+				t.Fatalf("%s: expected %d, got %d", v.bits, v.u, g)
+			}
+		}
+	}
+
+	for _, v := range []struct {
+		bits string
+		s    int64
+	}{
+		{bits: "1", s: 0},
+		{bits: "010", s: 1},
+		{bits: "011", s: -1},
+		{bits: "00100", s: 2},
+		{bits: "00101", s: -2},
+		{bits: "00110", s: 3},
+	} {
+		buf := &bytes.Buffer{}
+		w := NewBitsWriter(BitsWriterOptions{Writer: buf})
+		if err := w.WriteSignedExpGolomb(v.s); err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+		if err := w.Write(v.bits); err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+		if err := w.WriteN(uint8(0), (8-2*len(v.bits)%8)%8); err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+		r := NewBitsReader(BitsReaderOptions{Reader: bytes.NewReader(buf.Bytes())})
+		for i := 0; i < 2; i++ {
+			g, err := r.ReadSignedExpGolomb()
+			if err != nil {
+				t.Fatalf("expected no error, got %+v", err)
+			}
+			if g != v.s {
+				t.Fatalf("%s: expected %d, got %d", v.bits, v.s, g)
+			}
+		}
+	}
+
+	for _, v := range []struct {
+		bs []byte
+		fr func(r *BitsReaderBatch) any
+		fw func(w *BitsWriterBatch)
+		v  any
+	}{
+		{bs: []byte{0x00}, fr: func(r *BitsReaderBatch) any { return r.ReadULEB128() }, fw: func(w *BitsWriterBatch) { w.WriteULEB128(0) }, v: uint64(0)},
+		{bs: []byte{0x7f}, fr: func(r *BitsReaderBatch) any { return r.ReadULEB128() }, fw: func(w *BitsWriterBatch) { w.WriteULEB128(127) }, v: uint64(127)},
+		{bs: []byte{0x80, 0x01}, fr: func(r *BitsReaderBatch) any { return r.ReadULEB128() }, fw: func(w *BitsWriterBatch) { w.WriteULEB128(128) }, v: uint64(128)},
+		{bs: []byte{0xe5, 0x8e, 0x26}, fr: func(r *BitsReaderBatch) any { return r.ReadULEB128() }, fw: func(w *BitsWriterBatch) { w.WriteULEB128(624485) }, v: uint64(624485)},
+		{bs: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, fr: func(r *BitsReaderBatch) any { return r.ReadULEB128() }, fw: func(w *BitsWriterBatch) { w.WriteULEB128(math.MaxUint64) }, v: uint64(math.MaxUint64)},
+		{bs: []byte{0x00}, fr: func(r *BitsReaderBatch) any { return r.ReadSLEB128() }, fw: func(w *BitsWriterBatch) { w.WriteSLEB128(0) }, v: int64(0)},
+		{bs: []byte{0x02}, fr: func(r *BitsReaderBatch) any { return r.ReadSLEB128() }, fw: func(w *BitsWriterBatch) { w.WriteSLEB128(2) }, v: int64(2)},
+		{bs: []byte{0x7e}, fr: func(r *BitsReaderBatch) any { return r.ReadSLEB128() }, fw: func(w *BitsWriterBatch) { w.WriteSLEB128(-2) }, v: int64(-2)},
+		{bs: []byte{0xff, 0x00}, fr: func(r *BitsReaderBatch) any { return r.ReadSLEB128() }, fw: func(w *BitsWriterBatch) { w.WriteSLEB128(127) }, v: int64(127)},
+		{bs: []byte{0x80, 0x7f}, fr: func(r *BitsReaderBatch) any { return r.ReadSLEB128() }, fw: func(w *BitsWriterBatch) { w.WriteSLEB128(-128) }, v: int64(-128)},
+		{bs: []byte{0xc0, 0xbb, 0x78}, fr: func(r *BitsReaderBatch) any { return r.ReadSLEB128() }, fw: func(w *BitsWriterBatch) { w.WriteSLEB128(-123456) }, v: int64(-123456)},
+		{bs: []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x7f}, fr: func(r *BitsReaderBatch) any { return r.ReadSLEB128() }, fw: func(w *BitsWriterBatch) { w.WriteSLEB128(math.MinInt64) }, v: int64(math.MinInt64)},
+		{bs: []byte{0x25}, fr: func(r *BitsReaderBatch) any { return r.ReadQUICVarint() }, fw: func(w *BitsWriterBatch) { w.WriteQUICVarint(37) }, v: uint64(37)},
+		{bs: []byte{0x7b, 0xbd}, fr: func(r *BitsReaderBatch) any { return r.ReadQUICVarint() }, fw: func(w *BitsWriterBatch) { w.WriteQUICVarint(15293) }, v: uint64(15293)},
+		{bs: []byte{0x9d, 0x7f, 0x3e, 0x7d}, fr: func(r *BitsReaderBatch) any { return r.ReadQUICVarint() }, fw: func(w *BitsWriterBatch) { w.WriteQUICVarint(494878333) }, v: uint64(494878333)},
+		{bs: []byte{0xc2, 0x19, 0x7c, 0x5e, 0xff, 0x14, 0xe8, 0x8c}, fr: func(r *BitsReaderBatch) any { return r.ReadQUICVarint() }, fw: func(w *BitsWriterBatch) { w.WriteQUICVarint(151288809941952652) }, v: uint64(151288809941952652)},
+		{bs: []byte{0x05}, fr: func(r *BitsReaderBatch) any { return r.ReadExpandableSize() }, fw: func(w *BitsWriterBatch) { w.WriteExpandableSize(5) }, v: uint32(5)},
+		{bs: []byte{0x81, 0x48}, fr: func(r *BitsReaderBatch) any { return r.ReadExpandableSize() }, fw: func(w *BitsWriterBatch) { w.WriteExpandableSize(200) }, v: uint32(200)},
+		{bs: []byte{0xff, 0xff, 0xff, 0x7f}, fr: func(r *BitsReaderBatch) any { return r.ReadExpandableSize() }, fw: func(w *BitsWriterBatch) { w.WriteExpandableSize(1<<28 - 1) }, v: uint32(1<<28 - 1)},
+	} {
+		buf := &bytes.Buffer{}
+		w := NewBitsWriterBatch(NewBitsWriter(BitsWriterOptions{Writer: buf}))
+		v.fw(&w)
+		if err := w.Err(); err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+		if !bytes.Equal(v.bs, buf.Bytes()) {
+			t.Fatalf("%v: expected %x, got %x", v.v, v.bs, buf.Bytes())
+		}
+		r := NewBitsReaderBatch(NewBitsReaderFromBytes(v.bs, BitsReaderOptions{}))
+		if g := v.fr(&r); !reflect.DeepEqual(v.v, g) {
+			t.Fatalf("%x: expected %v, got %v", v.bs, v.v, g)
+		}
+		if err := r.Err(); err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+	}
+
+	// Non minimal encodings
+	r := NewBitsReaderFromBytes([]byte{0x40, 0x25, 0x80, 0x80, 0x05}, BitsReaderOptions{})
+	if g, err := r.ReadQUICVarint(); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := uint64(37); e != g {
+		t.Fatalf("expected %d, got %d", e, g)
+	}
+	if g, err := r.ReadExpandableSize(); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := uint32(5); e != g {
+		t.Fatalf("expected %d, got %d", e, g)
+	}
+
+	// Errors
+	w := NewBitsWriter(BitsWriterOptions{Writer: &bytes.Buffer{}})
+	if err := w.WriteExpGolomb(math.MaxUint64); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := w.WriteSignedExpGolomb(math.MinInt64); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := w.WriteQUICVarint(1 << 62); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := w.WriteExpandableSize(1 << 28); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := NewBitsReaderFromBytes(make([]byte, 9), BitsReaderOptions{}).ReadExpGolomb(); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := NewBitsReaderFromBytes(bytes.Repeat([]byte{0xff}, 10), BitsReaderOptions{}).ReadULEB128(); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := NewBitsReaderFromBytes(append(bytes.Repeat([]byte{0xff}, 9), 0x02), BitsReaderOptions{}).ReadULEB128(); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := NewBitsReaderFromBytes(append(bytes.Repeat([]byte{0x80}, 10), 0x00), BitsReaderOptions{}).ReadULEB128(); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := NewBitsReaderFromBytes(append(bytes.Repeat([]byte{0x80}, 9), 0x3f), BitsReaderOptions{}).ReadSLEB128(); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := NewBitsReaderFromBytes(append(bytes.Repeat([]byte{0x80}, 10), 0x00), BitsReaderOptions{}).ReadSLEB128(); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := NewBitsReaderFromBytes(bytes.Repeat([]byte{0xff}, 5), BitsReaderOptions{}).ReadExpandableSize(); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func BenchmarkBitsReader_ReadN(b *testing.B) {
+	bs := bytes.Repeat([]byte{0xff}, 16)
+	for _, n := range []int{1, 3, 8, 13, 32, 64} {
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r := NewBitsReaderFromBytes(bs, BitsReaderOptions{})
+				r.ReadN(n) //nolint:errcheck
+			}
+		})
+	}
+}
+
 func testByteHamming84Decode(i uint8) (o uint8, ok bool) {
 	p1, d1, p2, d2, p3, d3, p4, d4 := i>>7&0x1, i>>6&0x1, i>>5&0x1, i>>4&0x1, i>>3&0x1, i>>2&0x1, i>>1&0x1, i&0x1
 	testA := p1^d1^d3^d4 > 0
@@ -316,3 +800,146 @@ func TestByteParity(t *testing.T) {
 		}
 	}
 }
+
+func TestByteHamming84Encode(t *testing.T) {
+	for i := uint8(0); i < 16; i++ {
+		e := ByteHamming84Encode(i)
+		o, ok := ByteHamming84Decode(e)
+		if !ok {
+			t.Fatal("expected true, got false")
+		}
+		if i != o {
+			t.Fatalf("expected %+v, got %+v", i, o)
+		}
+		for b := 0; b < 8; b++ {
+			if o, ok = ByteHamming84Decode(e ^ 1<<b); !ok {
+				t.Fatal("expected true, got false")
+			} else if i != o {
+				t.Fatalf("expected %+v, got %+v", i, o)
+			}
+		}
+	}
+	if e, g := ByteHamming84Encode(0x5), ByteHamming84Encode(0xf5); e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+}
+
+func testByteHamming2418Encode(d uint32) (o uint32) {
+	// Place data bits
+	var ds int
+	for pos := 1; pos <= 23; pos++ {
+		if pos&(pos-1) == 0 {
+			continue
+		}
+		o |= (d >> ds & 0x1) << (pos - 1)
+		ds++
+	}
+
+	// Compute parity bits so that all tests have an odd parity
+	for i := 0; i < 5; i++ {
+		var p uint32 = 1
+		for pos := 1; pos <= 23; pos++ {
+			if pos>>i&0x1 > 0 {
+				p ^= o >> (pos - 1) & 0x1
+			}
+		}
+		o |= p << (1<<i - 1)
+	}
+	var p uint32 = 1
+	for pos := 1; pos <= 23; pos++ {
+		p ^= o >> (pos - 1) & 0x1
+	}
+	o |= p << 23
+	return
+}
+
+func TestByteHamming2418Decode(t *testing.T) {
+	for d := uint32(0); d < 1<<18; d++ {
+		e := testByteHamming2418Encode(d)
+		o, ok := ByteHamming2418Decode(e)
+		if !ok {
+			t.Fatalf("%x: expected true, got false", d)
+		}
+		if d != o {
+			t.Fatalf("expected %x, got %x", d, o)
+		}
+
+		// Check single and double bit errors on a subset to keep the test fast
+		if d%97 != 0 {
+			continue
+		}
+		for b1 := 0; b1 < 24; b1++ {
+			if o, ok = ByteHamming2418Decode(e ^ 1<<b1); !ok {
+				t.Fatalf("%x/%d: expected true, got false", d, b1)
+			} else if d != o {
+				t.Fatalf("%x/%d: expected %x, got %x", d, b1, d, o)
+			}
+			for b2 := b1 + 1; b2 < 24; b2++ {
+				if _, ok = ByteHamming2418Decode(e ^ 1<<b1 ^ 1<<b2); ok {
+					t.Fatalf("%x/%d/%d: expected false, got true", d, b1, b2)
+				}
+			}
+		}
+	}
+}
+
+func TestByteParityEncode(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		o := ByteParityEncode(uint8(i))
+		if !testByteParity(o) {
+			t.Fatalf("%d: expected odd parity", i)
+		}
+		if v, ok := ByteParity(o); !ok {
+			t.Fatal("expected true, got false")
+		} else if e := uint8(i) & 0x7f; e != v {
+			t.Fatalf("expected %+v, got %+v", e, v)
+		}
+	}
+}
+
+func TestByteReverse(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		var e uint8
+		for b := 0; b < 8; b++ {
+			e |= uint8(i) >> b & 0x1 << (7 - b)
+		}
+		if g := ByteReverse(uint8(i)); e != g {
+			t.Fatalf("expected %x, got %x", e, g)
+		}
+	}
+}
+
+func BenchmarkByteHamming84Decode(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ByteHamming84Decode(uint8(i))
+	}
+}
+
+func BenchmarkByteHamming84Encode(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ByteHamming84Encode(uint8(i))
+	}
+}
+
+func BenchmarkByteHamming2418Decode(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ByteHamming2418Decode(uint32(i))
+	}
+}
+
+func BenchmarkByteParityEncode(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ByteParityEncode(uint8(i))
+	}
+}
+
+func BenchmarkByteReverse(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ByteReverse(uint8(i))
+	}
+}
@@ -1,6 +1,14 @@
 package astikit
 
-import "fmt"
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"strings"
+)
 
 // BytesIterator represents an object capable of iterating sequentially and safely
 // through a slice of bytes. This is particularly useful when you need to iterate
@@ -53,6 +61,140 @@ func (i *BytesIterator) NextBytesNoCopy(n int) (bs []byte, err error) {
 	return
 }
 
+// PeekByte returns the next byte without advancing the offset
+func (i *BytesIterator) PeekByte() (b byte, err error) {
+	if len(i.bs) < i.offset+1 {
+		err = fmt.Errorf("astikit: slice length is %d, offset %d is invalid", len(i.bs), i.offset)
+		return
+	}
+	b = i.bs[i.offset]
+	return
+}
+
+// PeekBytes returns the n next bytes without advancing the offset
+func (i *BytesIterator) PeekBytes(n int) (bs []byte, err error) {
+	var b []byte
+	if b, err = i.peek(n); err != nil {
+		return
+	}
+	bs = make([]byte, n)
+	copy(bs, b)
+	return
+}
+
+func (i *BytesIterator) peek(n int) (bs []byte, err error) {
+	if n < 0 || len(i.bs) < i.offset+n {
+		err = fmt.Errorf("astikit: slice length is %d, offset %d is invalid", len(i.bs), i.offset+n)
+		return
+	}
+	bs = i.bs[i.offset : i.offset+n]
+	return
+}
+
+func (i *BytesIterator) uint(n int, bo binary.ByteOrder, advance bool) (o uint64, err error) {
+	var bs []byte
+	if bs, err = i.peek(n); err != nil {
+		return
+	}
+	if bo == binary.LittleEndian {
+		for idx := n - 1; idx >= 0; idx-- {
+			o = o<<8 | uint64(bs[idx])
+		}
+	} else {
+		for idx := 0; idx < n; idx++ {
+			o = o<<8 | uint64(bs[idx])
+		}
+	}
+	if advance {
+		i.offset += n
+	}
+	return
+}
+
+// NextUint16 returns the next uint16 using the provided byte order. A nil byte order
+// defaults to big endian.
+func (i *BytesIterator) NextUint16(bo binary.ByteOrder) (uint16, error) {
+	o, err := i.uint(2, bo, true)
+	return uint16(o), err
+}
+
+// NextUint24 returns the next 3 bytes as an uint32 using the provided byte order
+func (i *BytesIterator) NextUint24(bo binary.ByteOrder) (uint32, error) {
+	o, err := i.uint(3, bo, true)
+	return uint32(o), err
+}
+
+// NextUint32 returns the next uint32 using the provided byte order
+func (i *BytesIterator) NextUint32(bo binary.ByteOrder) (uint32, error) {
+	o, err := i.uint(4, bo, true)
+	return uint32(o), err
+}
+
+// NextUint64 returns the next uint64 using the provided byte order
+func (i *BytesIterator) NextUint64(bo binary.ByteOrder) (uint64, error) {
+	return i.uint(8, bo, true)
+}
+
+// NextFloat32 returns the next IEEE 754 float32 using the provided byte order
+func (i *BytesIterator) NextFloat32(bo binary.ByteOrder) (float32, error) {
+	o, err := i.uint(4, bo, true)
+	return math.Float32frombits(uint32(o)), err
+}
+
+// NextFloat64 returns the next IEEE 754 float64 using the provided byte order
+func (i *BytesIterator) NextFloat64(bo binary.ByteOrder) (float64, error) {
+	o, err := i.uint(8, bo, true)
+	return math.Float64frombits(o), err
+}
+
+// PeekUint16 returns the next uint16 without advancing the offset
+func (i *BytesIterator) PeekUint16(bo binary.ByteOrder) (uint16, error) {
+	o, err := i.uint(2, bo, false)
+	return uint16(o), err
+}
+
+// PeekUint24 returns the next 3 bytes as an uint32 without advancing the offset
+func (i *BytesIterator) PeekUint24(bo binary.ByteOrder) (uint32, error) {
+	o, err := i.uint(3, bo, false)
+	return uint32(o), err
+}
+
+// PeekUint32 returns the next uint32 without advancing the offset
+func (i *BytesIterator) PeekUint32(bo binary.ByteOrder) (uint32, error) {
+	o, err := i.uint(4, bo, false)
+	return uint32(o), err
+}
+
+// PeekUint64 returns the next uint64 without advancing the offset
+func (i *BytesIterator) PeekUint64(bo binary.ByteOrder) (uint64, error) {
+	return i.uint(8, bo, false)
+}
+
+// PeekFloat32 returns the next IEEE 754 float32 without advancing the offset
+func (i *BytesIterator) PeekFloat32(bo binary.ByteOrder) (float32, error) {
+	o, err := i.uint(4, bo, false)
+	return math.Float32frombits(uint32(o)), err
+}
+
+// PeekFloat64 returns the next IEEE 754 float64 without advancing the offset
+func (i *BytesIterator) PeekFloat64(bo binary.ByteOrder) (float64, error) {
+	o, err := i.uint(8, bo, false)
+	return math.Float64frombits(o), err
+}
+
+// Sub returns a child iterator bounded to the n next bytes and advances the offset by n.
+// The child iterator shares the buffer, its offsets are relative to its first byte and it
+// can't read past its last byte, which makes parsing nested length-prefixed structures safe.
+func (i *BytesIterator) Sub(n int) (s *BytesIterator, err error) {
+	var bs []byte
+	if bs, err = i.peek(n); err != nil {
+		return
+	}
+	s = NewBytesIterator(bs[:n:n])
+	i.offset += n
+	return
+}
+
 // Seek seeks to the nth byte
 func (i *BytesIterator) Seek(n int) {
 	i.offset = n
@@ -89,6 +231,338 @@ func (i *BytesIterator) Len() int {
 	return len(i.bs)
 }
 
+// Checksum writes the bytes located between the start offset and the current offset
+// in the checksum. This is useful to verify a checksum after having parsed a section.
+func (i *BytesIterator) Checksum(h hash.Hash, start int) error {
+	if start < 0 || start > i.offset || i.offset > len(i.bs) {
+		return fmt.Errorf("astikit: slice length is %d, span [%d:%d] is invalid", len(i.bs), start, i.offset)
+	}
+	h.Write(i.bs[start:i.offset]) //nolint:errcheck
+	return nil
+}
+
+// Default BytesStreamIterator options
+const (
+	bytesStreamIteratorDefaultWindow = 64 * 1024
+	bytesStreamIteratorReadSize      = 32 * 1024
+)
+
+// BytesStreamIterator represents an object capable of iterating sequentially and safely
+// through an io.Reader without loading the whole payload in memory. It has the same API
+// as BytesIterator except that seeking may fail.
+// Already read bytes are kept in a window so that seeking backward is possible as long
+// as the offset stays in the window. If the reader implements io.Seeker, seeking outside
+// of the window is possible as well. Offsets are relative to the reader position when
+// the iterator has been created.
+type BytesStreamIterator struct {
+	buf      *BufferPoolItem
+	bufStart int // Offset of the first byte in buf
+	offset   int
+	r        io.Reader
+	window   int
+}
+
+// BytesStreamIteratorOptions represents BytesStreamIterator options
+type BytesStreamIteratorOptions struct {
+	// Default is a new buffer pool
+	BufferPool *BufferPool
+	Reader     io.Reader
+	// Number of bytes before the current offset kept in memory to be able to seek backward
+	// Default is 64KiB
+	Window int
+}
+
+// NewBytesStreamIterator creates a new BytesStreamIterator
+// It is the responsibility of the caller to call Close()
+func NewBytesStreamIterator(o BytesStreamIteratorOptions) *BytesStreamIterator {
+	if o.BufferPool == nil {
+		o.BufferPool = NewBufferPool()
+	}
+	if o.Window <= 0 {
+		o.Window = bytesStreamIteratorDefaultWindow
+	}
+	return &BytesStreamIterator{
+		buf:    o.BufferPool.New(),
+		r:      o.Reader,
+		window: o.Window,
+	}
+}
+
+// Close releases the buffer
+func (i *BytesStreamIterator) Close() error {
+	return i.buf.Close()
+}
+
+func (i *BytesStreamIterator) bufEnd() int {
+	return i.bufStart + i.buf.Len()
+}
+
+func (i *BytesStreamIterator) reset(offset int) {
+	i.buf.Reset()
+	i.bufStart = offset
+}
+
+// fill makes sure the n bytes following the offset are in the buffer. The reader position
+// always matches the end of the buffer, even if it fails, so that the offset can be restored.
+func (i *BytesStreamIterator) fill(n int) (err error) {
+	// Offset is before the buffer or window start is after the buffer
+	if end, start := i.bufEnd(), i.offset-i.window; i.offset < i.bufStart || start > end {
+		if s, ok := i.r.(io.Seeker); ok {
+			if i.offset < i.bufStart {
+				start = i.offset
+			}
+			if _, err = s.Seek(int64(start-end), io.SeekCurrent); err != nil {
+				err = fmt.Errorf("astikit: seeking to offset %d failed: %w", start, err)
+				return
+			}
+			i.reset(start)
+		} else if i.offset < i.bufStart {
+			err = fmt.Errorf("astikit: offset %d is out of the [%d, %d] window and reader is not an io.Seeker", i.offset, i.bufStart, end)
+			return
+		}
+	}
+
+	// Read
+	// Bytes are read by chunks and dropped as soon as they are out of the window so that
+	// skipping bytes of a reader that is not an io.Seeker doesn't load them all in memory
+	for end := i.offset + n; i.bufEnd() < end; {
+		var c int64
+		if c, err = io.CopyN(i.buf, i.r, bytesStreamIteratorReadSize); err != nil && !errors.Is(err, io.EOF) {
+			err = fmt.Errorf("astikit: reading at offset %d failed: %w", i.bufEnd(), err)
+			return
+		}
+		err = nil
+		i.trim()
+		if c < bytesStreamIteratorReadSize && i.bufEnd() < end {
+			err = fmt.Errorf("astikit: stream length is %d, offset %d is invalid", i.bufEnd(), end)
+			return
+		}
+	}
+
+	// Drop bytes that are out of the window
+	i.trim()
+	return
+}
+
+// trim drops bytes that are out of the window
+func (i *BytesStreamIterator) trim() {
+	o := i.offset
+	if end := i.bufEnd(); o > end {
+		o = end
+	}
+	if d := o - i.window - i.bufStart; d > 0 {
+		i.buf.Next(d)
+		i.bufStart += d
+	}
+}
+
+func (i *BytesStreamIterator) next(n int) (bs []byte, err error) {
+	if n < 0 {
+		err = fmt.Errorf("astikit: invalid number of bytes %d", n)
+		return
+	}
+	if err = i.fill(n); err != nil {
+		return
+	}
+	start := i.offset - i.bufStart
+	bs = i.buf.Bytes()[start : start+n]
+	i.offset += n
+	return
+}
+
+// NextByte returns the next byte
+func (i *BytesStreamIterator) NextByte() (b byte, err error) {
+	var bs []byte
+	if bs, err = i.next(1); err != nil {
+		return
+	}
+	b = bs[0]
+	return
+}
+
+// NextBytes returns the n next bytes
+func (i *BytesStreamIterator) NextBytes(n int) (bs []byte, err error) {
+	var b []byte
+	if b, err = i.next(n); err != nil {
+		return
+	}
+	bs = make([]byte, n)
+	copy(bs, b)
+	return
+}
+
+// NextBytesNoCopy returns the n next bytes
+// Be careful with this function as it doesn't make a copy of returned data.
+// bs will point to internal BytesStreamIterator buffer and is only valid until the
+// next call to the iterator.
+func (i *BytesStreamIterator) NextBytesNoCopy(n int) (bs []byte, err error) {
+	return i.next(n)
+}
+
+// Seek seeks to the nth byte. It fails if n is before the start or after the end of the
+// stream.
+func (i *BytesStreamIterator) Seek(n int) (err error) {
+	// Offset is before the start of the stream
+	if n < 0 {
+		return fmt.Errorf("astikit: offset %d is invalid", n)
+	}
+
+	// Make sure offset is in the stream, and restore it otherwise
+	previous := i.offset
+	i.offset = n
+	if err = i.fill(0); err != nil {
+		i.offset = previous
+		return
+	}
+	return
+}
+
+// Skip skips the n previous/next bytes
+func (i *BytesStreamIterator) Skip(n int) error {
+	return i.Seek(i.offset + n)
+}
+
+// HasBytesLeft checks whether there are bytes left
+func (i *BytesStreamIterator) HasBytesLeft() bool {
+	return i.fill(1) == nil
+}
+
+// Offset returns the offset
+func (i *BytesStreamIterator) Offset() int {
+	return i.offset
+}
+
+const bytesHexDumpWidth = 16
+
+// BytesHexDump returns a hexdump of bs where each line contains the offset, the hex
+// values and the ASCII representation of 16 bytes
+func BytesHexDump(bs []byte) string {
+	var b strings.Builder
+	for offset := 0; offset < len(bs); offset += bytesHexDumpWidth {
+		bytesHexDumpLine(&b, bs, offset)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func bytesHexDumpLine(b *strings.Builder, bs []byte, offset int) {
+	fmt.Fprintf(b, "%08x ", offset)
+	for idx := offset; idx < offset+bytesHexDumpWidth; idx++ {
+		if idx-offset == bytesHexDumpWidth/2 {
+			b.WriteByte(' ')
+		}
+		if idx < len(bs) {
+			fmt.Fprintf(b, " %02x", bs[idx])
+		} else {
+			b.WriteString("   ")
+		}
+	}
+	b.WriteString("  |")
+	for idx := offset; idx < offset+bytesHexDumpWidth && idx < len(bs); idx++ {
+		if c := bs[idx]; c >= 0x20 && c <= 0x7e {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('.')
+		}
+	}
+	b.WriteByte('|')
+}
+
+// BytesDiff returns a human readable diff between expected and got, or an empty string
+// if they are equal. Lines containing differences are dumped for both buffers, followed
+// by the offset, the binary values and the differing bits of each differing byte.
+// If annotations are provided, typically retrieved from BitsWriter.Annotations(), the
+// fields containing differing bits are reported as well.
+func BytesDiff(expected, got []byte, annotations ...BitsAnnotation) string {
+	var b strings.Builder
+	if len(expected) != len(got) {
+		fmt.Fprintf(&b, "expected %d bytes, got %d bytes\n", len(expected), len(got))
+	}
+
+	// Loop through lines
+	l := len(expected)
+	if len(got) > l {
+		l = len(got)
+	}
+	for offset := 0; offset < l; offset += bytesHexDumpWidth {
+		// Get differing bytes
+		var idxs []int
+		for idx := offset; idx < offset+bytesHexDumpWidth && idx < l; idx++ {
+			if idx >= len(expected) || idx >= len(got) || expected[idx] != got[idx] {
+				idxs = append(idxs, idx)
+			}
+		}
+		if len(idxs) == 0 {
+			continue
+		}
+
+		// Dump lines
+		b.WriteString("- ")
+		bytesHexDumpLine(&b, expected, offset)
+		b.WriteString("\n+ ")
+		bytesHexDumpLine(&b, got, offset)
+		b.WriteByte('\n')
+
+		// Add markers
+		m := []byte(strings.Repeat(" ", 11+3*bytesHexDumpWidth+1))
+		for _, idx := range idxs {
+			p := 12 + 3*(idx-offset)
+			if idx-offset >= bytesHexDumpWidth/2 {
+				p++
+			}
+			m[p], m[p+1] = '^', '^'
+		}
+		b.WriteString(strings.TrimRight(string(m), " "))
+		b.WriteByte('\n')
+
+		// Add details
+		for _, idx := range idxs {
+			fmt.Fprintf(&b, "  %08x: ", idx)
+			var diff byte
+			switch {
+			case idx >= len(expected):
+				fmt.Fprintf(&b, "expected nothing, got %#02x (%08b)", got[idx], got[idx])
+				diff = 0xff
+			case idx >= len(got):
+				fmt.Fprintf(&b, "expected %#02x (%08b), got nothing", expected[idx], expected[idx])
+				diff = 0xff
+			default:
+				diff = expected[idx] ^ got[idx]
+				fmt.Fprintf(&b, "expected %#02x (%08b), got %#02x (%08b), diff %08b", expected[idx], expected[idx], got[idx], got[idx], diff)
+			}
+			if names := bytesDiffFields(annotations, idx, diff); len(names) > 0 {
+				fmt.Fprintf(&b, " in %s", strings.Join(names, ", "))
+			}
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// bytesDiffFields returns the names of the annotated fields containing the set bits of
+// diff in the byte at offset idx
+func bytesDiffFields(annotations []BitsAnnotation, idx int, diff byte) (names []string) {
+	if len(annotations) == 0 {
+		return
+	}
+	for bit := 0; bit < 8; bit++ {
+		if diff&(0x80>>bit) == 0 {
+			continue
+		}
+		pos := uint64(idx*8 + bit)
+		var a *BitsAnnotation
+		for i := range annotations {
+			if annotations[i].Position <= pos && (a == nil || annotations[i].Position >= a.Position) {
+				a = &annotations[i]
+			}
+		}
+		if a != nil && (len(names) == 0 || names[len(names)-1] != a.Name) {
+			names = append(names, a.Name)
+		}
+	}
+	return
+}
+
 const (
 	padRight = "right"
 	padLeft  = "left"
@@ -2,6 +2,10 @@ package astikit
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
 	"testing"
 )
 
@@ -74,6 +78,350 @@ func TestBytesIterator(t *testing.T) {
 		t.Fatalf("expected %+v, got %+v", e, g)
 	}
 }
+
+func TestBytesIteratorTyped(t *testing.T) {
+	i := NewBytesIterator([]byte{
+		0x01, 0x02, 0x01, 0x02, 0x01, 0x02, 0x03, 0x01, 0x02, 0x03, 0x04,
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x3f, 0x80, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x04, 0xc0,
+	})
+	if b, err := i.PeekByte(); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := byte(0x01); e != b {
+		t.Fatalf("expected %x, got %x", e, b)
+	}
+	if bs, err := i.PeekBytes(2); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := []byte{0x01, 0x02}; !bytes.Equal(e, bs) {
+		t.Fatalf("expected %x, got %x", e, bs)
+	}
+	if v, err := i.PeekUint16(nil); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := uint16(0x0102); e != v {
+		t.Fatalf("expected %x, got %x", e, v)
+	}
+	if v, err := i.NextUint16(binary.BigEndian); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := uint16(0x0102); e != v {
+		t.Fatalf("expected %x, got %x", e, v)
+	}
+	if v, err := i.NextUint16(binary.LittleEndian); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := uint16(0x0201); e != v {
+		t.Fatalf("expected %x, got %x", e, v)
+	}
+	if v, err := i.PeekUint24(binary.LittleEndian); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := uint32(0x030201); e != v {
+		t.Fatalf("expected %x, got %x", e, v)
+	}
+	if v, err := i.NextUint24(binary.BigEndian); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := uint32(0x010203); e != v {
+		t.Fatalf("expected %x, got %x", e, v)
+	}
+	if v, err := i.PeekUint32(binary.BigEndian); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := uint32(0x01020304); e != v {
+		t.Fatalf("expected %x, got %x", e, v)
+	}
+	if v, err := i.NextUint32(binary.LittleEndian); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := uint32(0x04030201); e != v {
+		t.Fatalf("expected %x, got %x", e, v)
+	}
+	if v, err := i.PeekUint64(binary.LittleEndian); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := uint64(0x0807060504030201); e != v {
+		t.Fatalf("expected %x, got %x", e, v)
+	}
+	if v, err := i.NextUint64(binary.BigEndian); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := uint64(0x0102030405060708); e != v {
+		t.Fatalf("expected %x, got %x", e, v)
+	}
+	if v, err := i.PeekFloat32(binary.BigEndian); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := float32(1); e != v {
+		t.Fatalf("expected %v, got %v", e, v)
+	}
+	if v, err := i.NextFloat32(binary.BigEndian); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := float32(1); e != v {
+		t.Fatalf("expected %v, got %v", e, v)
+	}
+	if v, err := i.PeekFloat64(binary.LittleEndian); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := float64(-2.5); e != v {
+		t.Fatalf("expected %v, got %v", e, v)
+	}
+	if v, err := i.NextFloat64(binary.LittleEndian); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := float64(-2.5); e != v {
+		t.Fatalf("expected %v, got %v", e, v)
+	}
+	if i.HasBytesLeft() {
+		t.Fatal("expected false, got true")
+	}
+	if _, err := i.PeekByte(); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := i.NextUint16(nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if e, g := 31, i.Offset(); e != g {
+		t.Fatalf("expected %v, got %v", e, g)
+	}
+}
+
+func TestBytesIteratorSub(t *testing.T) {
+	// Length-prefixed descriptors followed by a trailing byte
+	i := NewBytesIterator([]byte{0x02, 0x0a, 0x0b, 0x01, 0x0c, 0xff})
+	var ds [][]byte
+	for idx := 0; idx < 2; idx++ {
+		l, err := i.NextByte()
+		if err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+		s, err := i.Sub(int(l))
+		if err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+		if e, g := int(l), s.Len(); e != g {
+			t.Fatalf("expected %v, got %v", e, g)
+		}
+		if _, err = s.NextBytes(int(l) + 1); err == nil {
+			t.Fatal("expected error")
+		}
+		ds = append(ds, s.Dump())
+		if _, err = s.NextByte(); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	if e := [][]byte{{0x0a, 0x0b}, {0x0c}}; !reflect.DeepEqual(e, ds) {
+		t.Fatalf("expected %+v, got %+v", e, ds)
+	}
+	if b, err := i.NextByte(); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := byte(0xff); e != b {
+		t.Fatalf("expected %x, got %x", e, b)
+	}
+	if _, err := i.Sub(1); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestBytesStreamIterator(t *testing.T) {
+	bs := make([]byte, 3*bytesStreamIteratorReadSize)
+	for idx := range bs {
+		bs[idx] = byte(idx)
+	}
+
+	// Reader is not an io.Seeker
+	i := NewBytesStreamIterator(BytesStreamIteratorOptions{
+		Reader: struct{ io.Reader }{Reader: bytes.NewReader(bs)},
+		Window: 4,
+	})
+	defer i.Close()
+	b, err := i.NextByte()
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e := byte(0); e != b {
+		t.Fatalf("expected %v, got %v", e, b)
+	}
+	if err = i.Skip(bytesStreamIteratorReadSize); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	b1, err := i.NextBytes(2)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e := bs[bytesStreamIteratorReadSize+1 : bytesStreamIteratorReadSize+3]; !bytes.Equal(e, b1) {
+		t.Fatalf("expected %+v, got %+v", e, b1)
+	}
+	if err = i.Skip(-4); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	b2, err := i.NextBytesNoCopy(2)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e := bs[bytesStreamIteratorReadSize-1 : bytesStreamIteratorReadSize+1]; !bytes.Equal(e, b2) {
+		t.Fatalf("expected %+v, got %+v", e, b2)
+	}
+	if err = i.Seek(1); err == nil {
+		t.Fatal("expected error")
+	}
+	if err = i.Seek(2*bytesStreamIteratorReadSize + 10); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e, g := 2*bytesStreamIteratorReadSize+10, i.Offset(); e != g {
+		t.Fatalf("expected %v, got %v", e, g)
+	}
+	if b, err = i.NextByte(); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := bs[2*bytesStreamIteratorReadSize+10]; e != b {
+		t.Fatalf("expected %v, got %v", e, b)
+	}
+	if !i.HasBytesLeft() {
+		t.Fatal("expected true, got false")
+	}
+	if err = i.Seek(len(bs) - 1); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if _, err = i.NextBytes(2); err == nil {
+		t.Fatal("expected error")
+	} else if e, g := fmt.Sprintf("astikit: stream length is %d, offset %d is invalid", len(bs), len(bs)+1), err.Error(); e != g {
+		t.Fatalf("expected %s, got %s", e, g)
+	}
+	if _, err = i.NextByte(); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if i.HasBytesLeft() {
+		t.Fatal("expected false, got true")
+	}
+
+	// Reader is an io.Seeker
+	i = NewBytesStreamIterator(BytesStreamIteratorOptions{
+		Reader: bytes.NewReader(bs),
+		Window: 4,
+	})
+	defer i.Close()
+	if err = i.Seek(2 * bytesStreamIteratorReadSize); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if b, err = i.NextByte(); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := bs[2*bytesStreamIteratorReadSize]; e != b {
+		t.Fatalf("expected %v, got %v", e, b)
+	}
+	if err = i.Seek(1); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if b1, err = i.NextBytes(3); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := bs[1:4]; !bytes.Equal(e, b1) {
+		t.Fatalf("expected %+v, got %+v", e, b1)
+	}
+
+	// Invalid offsets
+	if err = i.Seek(-1); err == nil {
+		t.Fatal("expected error")
+	}
+	if err = i.Skip(-5); err == nil {
+		t.Fatal("expected error")
+	}
+	if err = i.Seek(len(bs) + 1); err == nil {
+		t.Fatal("expected error")
+	}
+	if e, g := 4, i.Offset(); e != g {
+		t.Fatalf("expected %v, got %v", e, g)
+	}
+	if b, err = i.NextByte(); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	} else if e := bs[4]; e != b {
+		t.Fatalf("expected %v, got %v", e, b)
+	}
+	if err = i.Seek(len(bs)); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if i.HasBytesLeft() {
+		t.Fatal("expected false, got true")
+	}
+
+	// Seeking after the end of a reader that is not an io.Seeker
+	for _, v := range []struct {
+		err    bool
+		window int
+	}{
+		{window: 2 * len(bs)},
+		{err: true, window: 4},
+	} {
+		i = NewBytesStreamIterator(BytesStreamIteratorOptions{
+			Reader: struct{ io.Reader }{Reader: bytes.NewReader(bs)},
+			Window: v.window,
+		})
+		defer i.Close()
+		if _, err = i.NextBytes(2); err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+		if err = i.Seek(len(bs) + 10); err == nil {
+			t.Fatal("expected error")
+		}
+		if e, g := 2, i.Offset(); e != g {
+			t.Fatalf("expected %v, got %v", e, g)
+		}
+		// Bytes out of the window have been dropped while looking for the end of the stream
+		b, err = i.NextByte()
+		if v.err {
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		} else if e := bs[2]; e != b {
+			t.Fatalf("expected %v, got %v", e, b)
+		}
+	}
+}
+
+func TestBytesHexDump(t *testing.T) {
+	if e, g := "", BytesHexDump(nil); e != g {
+		t.Fatalf("expected %q, got %q", e, g)
+	}
+	if e, g := "00000000  47 41 00 68 65 6c 6c 6f  20 77 6f 72 6c 64 2c 20  |GA.hello world, |\n"+
+		"00000010  74 68 69 73                                       |this|\n", BytesHexDump([]byte("GA\x00hello world, this")); e != g {
+		t.Fatalf("expected %q, got %q", e, g)
+	}
+}
+
+func TestBytesDiff(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewBitsWriter(BitsWriterOptions{Writer: buf})
+	b := NewBitsWriterBatch(w)
+	b.Annotate("sync")
+	b.Write(uint8(0x47))
+	b.Annotate("flags")
+	b.Write("010")
+	b.Annotate("pid")
+	b.WriteN(uint16(0x100), 13)
+	b.Annotate("payload")
+	b.Write([]byte("hello world, this"))
+	if err := b.Err(); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e, g := []BitsAnnotation{{Name: "sync"}, {Name: "flags", Position: 8}, {Name: "pid", Position: 11}, {Name: "payload", Position: 24}}, w.Annotations(); !reflect.DeepEqual(e, g) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+
+	if e, g := "", BytesDiff(buf.Bytes(), buf.Bytes(), w.Annotations()...); e != g {
+		t.Fatalf("expected %q, got %q", e, g)
+	}
+	if e, g := "expected 20 bytes, got 21 bytes\n"+
+		"- 00000000  47 41 00 68 65 6c 6c 6f  20 77 6f 72 6c 64 2c 20  |GA.hello world, |\n"+
+		"+ 00000000  47 61 01 68 65 6c 6c 6f  20 77 6f 72 6c 64 2c 20  |Ga.hello world, |\n"+
+		"               ^^ ^^\n"+
+		"  00000001: expected 0x41 (01000001), got 0x61 (01100001), diff 00100000 in flags\n"+
+		"  00000002: expected 0x00 (00000000), got 0x01 (00000001), diff 00000001 in pid\n"+
+		"- 00000010  74 68 69 73                                       |this|\n"+
+		"+ 00000010  74 68 69 53 21                                    |thiS!|\n"+
+		"                     ^^ ^^\n"+
+		"  00000013: expected 0x73 (01110011), got 0x53 (01010011), diff 00100000 in payload\n"+
+		"  00000014: expected nothing, got 0x21 (00100001) in payload\n", BytesDiff(buf.Bytes(), []byte("\x47\x61\x01hello world, thiS!"), w.Annotations()...); e != g {
+		t.Fatalf("expected %s, got %s", e, g)
+	}
+	if e, g := "expected 2 bytes, got 1 bytes\n"+
+		"- 00000000  01 02                                             |..|\n"+
+		"+ 00000000  01                                                |.|\n"+
+		"               ^^\n"+
+		"  00000001: expected 0x02 (00000010), got nothing\n", BytesDiff([]byte{1, 2}, []byte{1}); e != g {
+		t.Fatalf("expected %s, got %s", e, g)
+	}
+}
+
 func TestBytesPad(t *testing.T) {
 	if e, g := []byte("test"), BytesPad([]byte("test"), ' ', 4); !bytes.Equal(e, g) {
 		t.Fatalf("expected %+v, got %+v", e, g)
@@ -0,0 +1,115 @@
+package astikit
+
+import (
+	"hash"
+	"hash/adler32"
+)
+
+// checksumCRC is a table-driven, non-reflected CRC of width 8, 16 or 32
+type checksumCRC struct {
+	init  uint32
+	mask  uint32
+	tab   *[256]uint32
+	v     uint32
+	width int
+}
+
+func newChecksumCRCTab(width int, poly uint32) *[256]uint32 {
+	top := uint32(1) << (width - 1)
+	mask := uint32(1<<width - 1)
+	tab := &[256]uint32{}
+	for i := range tab {
+		c := uint32(i) << (width - 8)
+		for j := 0; j < 8; j++ {
+			if c&top > 0 {
+				c = c<<1 ^ poly
+			} else {
+				c <<= 1
+			}
+		}
+		tab[i] = c & mask
+	}
+	return tab
+}
+
+var (
+	checksumCRC8Tab       = newChecksumCRCTab(8, 0x07)
+	checksumCRC16CCITTTab = newChecksumCRCTab(16, 0x1021)
+	checksumCRC32MPEG2Tab = newChecksumCRCTab(32, 0x04c11db7)
+)
+
+func newChecksumCRC(width int, init uint32, tab *[256]uint32) *checksumCRC {
+	return &checksumCRC{
+		init:  init,
+		mask:  uint32(1<<width - 1),
+		tab:   tab,
+		v:     init,
+		width: width,
+	}
+}
+
+// NewCRC8 creates a CRC-8 checksum (poly 0x07, init 0x00)
+func NewCRC8() hash.Hash32 {
+	return newChecksumCRC(8, 0, checksumCRC8Tab)
+}
+
+// NewCRC16CCITT creates a CRC-16/CCITT checksum (poly 0x1021, init 0xffff)
+func NewCRC16CCITT() hash.Hash32 {
+	return newChecksumCRC(16, 0xffff, checksumCRC16CCITTTab)
+}
+
+// NewCRC32MPEG2 creates a CRC-32/MPEG-2 checksum (poly 0x04c11db7, init 0xffffffff), which
+// is the one used in MPEG-TS sections
+func NewCRC32MPEG2() hash.Hash32 {
+	return newChecksumCRC(32, 0xffffffff, checksumCRC32MPEG2Tab)
+}
+
+// NewAdler32 creates an Adler-32 checksum
+func NewAdler32() hash.Hash32 {
+	return adler32.New()
+}
+
+// Write implements the io.Writer interface
+func (c *checksumCRC) Write(p []byte) (int, error) {
+	for _, b := range p {
+		c.v = (c.tab[byte(c.v>>(c.width-8))^b] ^ c.v<<8) & c.mask
+	}
+	return len(p), nil
+}
+
+// Sum implements the hash.Hash interface
+func (c *checksumCRC) Sum(b []byte) []byte {
+	for i := c.Size() - 1; i >= 0; i-- {
+		b = append(b, byte(c.v>>(i*8)))
+	}
+	return b
+}
+
+// Sum32 implements the hash.Hash32 interface
+func (c *checksumCRC) Sum32() uint32 {
+	return c.v
+}
+
+// Reset implements the hash.Hash interface
+func (c *checksumCRC) Reset() {
+	c.v = c.init
+}
+
+// Size implements the hash.Hash interface
+func (c *checksumCRC) Size() int {
+	return c.width / 8
+}
+
+// BlockSize implements the hash.Hash interface
+func (c *checksumCRC) BlockSize() int {
+	return 1
+}
+
+// NewChecksumCallback creates a callback that writes every byte it receives into the
+// checksum. It can be used as a BitsWriter write callback or a BitsReader read callback
+// to compute a checksum while writing or reading.
+func NewChecksumCallback(h hash.Hash) func([]byte) {
+	return func(b []byte) {
+		h.Write(b) //nolint:errcheck
+	}
+}
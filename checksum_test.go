@@ -0,0 +1,133 @@
+package astikit
+
+import (
+	"bytes"
+	"hash"
+	"reflect"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	for _, v := range []struct {
+		fn  func() hash.Hash32
+		sum []byte
+		v   uint32
+	}{
+		{fn: NewCRC8, sum: []byte{0xf4}, v: 0xf4},
+		{fn: NewCRC16CCITT, sum: []byte{0x29, 0xb1}, v: 0x29b1},
+		{fn: NewCRC32MPEG2, sum: []byte{0x03, 0x76, 0xe6, 0xe7}, v: 0x0376e6e7},
+		{fn: NewAdler32, sum: []byte{0x09, 0x1e, 0x01, 0xde}, v: 0x091e01de},
+	} {
+		h := v.fn()
+		h.Write([]byte("1234"))  //nolint:errcheck
+		h.Write([]byte("56789")) //nolint:errcheck
+		if e, g := v.v, h.Sum32(); e != g {
+			t.Fatalf("expected %x, got %x", e, g)
+		}
+		if e, g := v.sum, h.Sum(nil); !bytes.Equal(e, g) {
+			t.Fatalf("expected %x, got %x", e, g)
+		}
+		if e, g := len(v.sum), h.Size(); e != g {
+			t.Fatalf("expected %d, got %d", e, g)
+		}
+		h.Reset()
+		h.Write([]byte("123456789")) //nolint:errcheck
+		if e, g := v.v, h.Sum32(); e != g {
+			t.Fatalf("expected %x, got %x", e, g)
+		}
+	}
+}
+
+func TestChecksumCallback(t *testing.T) {
+	// Write a PAT section and its CRC
+	buf := &bytes.Buffer{}
+	h := NewCRC32MPEG2()
+	w := NewBitsWriter(BitsWriterOptions{
+		WriteCallback: NewChecksumCallback(h),
+		Writer:        buf,
+	})
+	b := NewBitsWriterBatch(w)
+	b.Write(uint8(0x00))
+	b.Write("1011")
+	b.WriteN(uint16(13), 12)
+	b.Write(uint16(1))
+	b.Write("11000001")
+	b.Write(uint16(0))
+	b.Write(uint16(1))
+	b.Write("111")
+	b.WriteN(uint16(0x1000), 13)
+	if err := b.Err(); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	w.SetWriteCallback(nil)
+	b.Write(h.Sum32())
+	if err := b.Err(); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e, g := []byte{0x00, 0xb0, 0x0d, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x01, 0xf0, 0x00, 0x2a, 0xb1, 0x04, 0xb2}, buf.Bytes(); !reflect.DeepEqual(e, g) {
+		t.Fatalf("expected %x, got %x", e, g)
+	}
+
+	// Verify while reading
+	h.Reset()
+	r := NewBitsReader(BitsReaderOptions{
+		ReadCallback: NewChecksumCallback(h),
+		Reader:       bytes.NewReader(buf.Bytes()),
+	})
+	if _, err := r.ReadBytes(12); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	r.SetReadCallback(nil)
+	crc, err := r.ReadUint32()
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e, g := crc, h.Sum32(); e != g {
+		t.Fatalf("expected %x, got %x", e, g)
+	}
+
+	// Verify with a bytes iterator span
+	i := NewBytesIterator(buf.Bytes())
+	i.Skip(12)
+	h.Reset()
+	if err := i.Checksum(h, 0); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e, g := crc, h.Sum32(); e != g {
+		t.Fatalf("expected %x, got %x", e, g)
+	}
+	if err := i.Checksum(h, 13); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Checksum of the whole section including its CRC is 0
+	h.Reset()
+	i.Skip(4)
+	if err := i.Checksum(h, 0); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e, g := uint32(0), h.Sum32(); e != g {
+		t.Fatalf("expected %x, got %x", e, g)
+	}
+}
+
+func BenchmarkChecksum(b *testing.B) {
+	bs := bytes.Repeat([]byte{0xa5}, 188)
+	for _, v := range []struct {
+		fn   func() hash.Hash32
+		name string
+	}{
+		{fn: NewCRC8, name: "crc8"},
+		{fn: NewCRC16CCITT, name: "crc16"},
+		{fn: NewCRC32MPEG2, name: "crc32"},
+		{fn: NewAdler32, name: "adler32"},
+	} {
+		b.Run(v.name, func(b *testing.B) {
+			h := v.fn()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				h.Write(bs) //nolint:errcheck
+			}
+		})
+	}
+}
@@ -3,10 +3,18 @@ package astikit
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
+	"math"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
@@ -15,6 +23,8 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -71,40 +81,110 @@ type HTTPClient interface {
 
 // HTTPSender represents an object capable of sending http requests
 type HTTPSender struct {
-	client     HTTPClient
-	l          SeverityLogger
-	retryFunc  HTTPSenderRetryFunc
-	retryMax   int
-	retrySleep time.Duration
-	timeout    time.Duration
+	backoff        HTTPSenderBackoff
+	cb             *httpSenderCircuitBreaker
+	client         HTTPClient
+	l              SeverityLogger
+	retryErrorFunc HTTPSenderRetryErrorFunc
+	retryFunc      HTTPSenderRetryFunc
+	retryMax       int
+	retrySleepMax  time.Duration
+	timeout        time.Duration
 }
 
 // HTTPSenderRetryFunc is a function that decides whether to retry an HTTP request
 type HTTPSenderRetryFunc func(resp *http.Response) bool
 
+// HTTPSenderRetryErrorFunc is a function that decides whether to retry an HTTP request
+// whose sending has failed
+type HTTPSenderRetryErrorFunc func(err error) bool
+
+// HTTPSenderBackoff is a function that returns how long to sleep before the nth retry
+// (starting at 1) knowing how long was slept before the previous one
+type HTTPSenderBackoff func(retry int, previous time.Duration) time.Duration
+
+// HTTPSenderConstantBackoff always sleeps d
+func HTTPSenderConstantBackoff(d time.Duration) HTTPSenderBackoff {
+	return func(retry int, previous time.Duration) time.Duration { return d }
+}
+
+// HTTPSenderExponentialBackoff sleeps base, then base*factor, then base*factor^2, etc.
+// until it saturates at the max duration. If factor is <= 1, it defaults to 2.
+func HTTPSenderExponentialBackoff(base time.Duration, factor float64) HTTPSenderBackoff {
+	if factor <= 1 {
+		factor = 2
+	}
+	return func(retry int, previous time.Duration) time.Duration {
+		if d := float64(base) * math.Pow(factor, float64(retry-1)); d < math.MaxInt64 {
+			return time.Duration(d)
+		}
+		return math.MaxInt64
+	}
+}
+
+// HTTPSenderDecorrelatedJitterBackoff sleeps a random duration between base and 3 times
+// the previous sleep
+// https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+func HTTPSenderDecorrelatedJitterBackoff(base time.Duration) HTTPSenderBackoff {
+	return func(retry int, previous time.Duration) time.Duration {
+		if previous < base {
+			previous = base
+		} else if previous > math.MaxInt64/3 {
+			previous = math.MaxInt64 / 3
+		}
+		if d := 3*previous - base; d > 0 {
+			return base + time.Duration(rand.Int63n(int64(d)))
+		}
+		return base
+	}
+}
+
 // HTTPSenderOptions represents HTTPSender options
 type HTTPSenderOptions struct {
-	Client     HTTPClient
-	Logger     StdLogger
+	// Defaults to a constant backoff of RetrySleep
+	Backoff HTTPSenderBackoff
+	// Disabled if nil
+	CircuitBreaker *HTTPSenderCircuitBreakerOptions
+	Client         HTTPClient
+	Logger         StdLogger
+	// Defaults to retrying on timeouts and connection resets, refusals and aborts, but not
+	// on DNS failures
+	RetryErrorFunc HTTPSenderRetryErrorFunc
+	// Defaults to retrying on 429 and 5xx status codes
 	RetryFunc  HTTPSenderRetryFunc
 	RetryMax   int
 	RetrySleep time.Duration
-	Timeout    time.Duration
+	// Caps both the backoff and the Retry-After header sleeps. Defaults to no cap for the
+	// backoff and to 1 minute for the Retry-After header, so that a server can't make the
+	// sender sleep for an unreasonable amount of time.
+	RetrySleepMax time.Duration
+	Timeout       time.Duration
 }
 
 // NewHTTPSender creates a new HTTP sender
 func NewHTTPSender(o HTTPSenderOptions) (s *HTTPSender) {
 	s = &HTTPSender{
-		client:     o.Client,
-		l:          AdaptStdLogger(o.Logger),
-		retryFunc:  o.RetryFunc,
-		retryMax:   o.RetryMax,
-		retrySleep: o.RetrySleep,
-		timeout:    o.Timeout,
+		backoff:        o.Backoff,
+		client:         o.Client,
+		l:              AdaptStdLogger(o.Logger),
+		retryErrorFunc: o.RetryErrorFunc,
+		retryFunc:      o.RetryFunc,
+		retryMax:       o.RetryMax,
+		retrySleepMax:  o.RetrySleepMax,
+		timeout:        o.Timeout,
+	}
+	if s.backoff == nil {
+		s.backoff = HTTPSenderConstantBackoff(o.RetrySleep)
+	}
+	if o.CircuitBreaker != nil {
+		s.cb = newHTTPSenderCircuitBreaker(*o.CircuitBreaker)
 	}
 	if s.client == nil {
 		s.client = &http.Client{}
 	}
+	if s.retryErrorFunc == nil {
+		s.retryErrorFunc = HTTPSenderDefaultRetryErrorFunc
+	}
 	if s.retryFunc == nil {
 		s.retryFunc = s.defaultHTTPRetryFunc
 	}
@@ -112,7 +192,21 @@ func NewHTTPSender(o HTTPSenderOptions) (s *HTTPSender) {
 }
 
 func (s *HTTPSender) defaultHTTPRetryFunc(resp *http.Response) bool {
-	return resp.StatusCode >= http.StatusInternalServerError
+	return resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// HTTPSenderDefaultRetryErrorFunc retries on timeouts and connection resets, refusals and
+// aborts, but not on DNS failures
+func HTTPSenderDefaultRetryErrorFunc(err error) bool {
+	var dnsError *net.DNSError
+	if errors.As(err, &dnsError) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNABORTED) {
+		return true
+	}
+	var netError net.Error
+	return errors.As(err, &netError) && netError.Timeout()
 }
 
 // Send sends a new *http.Request
@@ -122,6 +216,9 @@ func (s *HTTPSender) Send(req *http.Request) (*http.Response, error) {
 
 // SendWithTimeout sends a new *http.Request with a timeout
 func (s *HTTPSender) SendWithTimeout(req *http.Request, timeout time.Duration) (*http.Response, error) {
+	// Get parent context
+	parent := req.Context()
+
 	// Timeout
 	if timeout > 0 {
 		// Create context
@@ -133,14 +230,18 @@ func (s *HTTPSender) SendWithTimeout(req *http.Request, timeout time.Duration) (
 	}
 
 	// Send
-	return s.send(req, timeout)
+	return s.send(parent, req, timeout)
 }
 
-func (s *HTTPSender) send(req *http.Request, timeout time.Duration) (*http.Response, error) {
+// send sends the request whose context derives from parent which is the context provided
+// by the caller, before the sender's own timeout has been applied
+func (s *HTTPSender) send(parent context.Context, req *http.Request, timeout time.Duration) (*http.Response, error) {
 	// Set name
 	name := req.Method + " request"
+	var host string
 	if req.URL != nil {
 		name += " to " + req.URL.String()
+		host = req.URL.Host
 	}
 
 	// Timeout
@@ -152,21 +253,43 @@ func (s *HTTPSender) send(req *http.Request, timeout time.Duration) (*http.Respo
 	// We start at retryMax + 1 so that it runs at least once even if retryMax == 0
 	var resp *http.Response
 	var errDo error
+	var sleep time.Duration
 	tries := 0
 	for retriesLeft := s.retryMax + 1; retriesLeft > 0; retriesLeft-- {
 		// Get request name
 		nr := name + " (" + strconv.Itoa(s.retryMax-retriesLeft+2) + "/" + strconv.Itoa(s.retryMax+1) + ")"
 		tries++
 
+		// Check circuit breaker
+		if s.cb != nil {
+			if err := s.cb.allow(host); err != nil {
+				return nil, err
+			}
+		}
+
 		// Send request
 		s.l.Debugf("astikit: sending %s", nr)
-		if resp, errDo = s.client.Do(req); errDo != nil {
-			// Stop if error is not temporary
-			if netError, ok := errDo.(net.Error); !ok || !netError.Timeout() {
-				return nil, errDo
+		resp, errDo = s.client.Do(req)
+
+		// Check whether request has failed
+		failed := errDo != nil || s.retryFunc(resp)
+
+		// Update circuit breaker
+		if s.cb != nil {
+			if errDo != nil && parent.Err() != nil {
+				// Cancellations by the caller are not the host's fault, unlike the sender's
+				// own timeout expiring
+				s.cb.abort(host)
+			} else {
+				s.cb.report(host, !failed)
 			}
 		}
 
+		// Stop if error is not retryable
+		if errDo != nil && !s.retryErrorFunc(errDo) {
+			return nil, errDo
+		}
+
 		// Request has timed out
 		if err := req.Context().Err(); err != nil {
 			// Make sure to close response body
@@ -177,13 +300,50 @@ func (s *HTTPSender) send(req *http.Request, timeout time.Duration) (*http.Respo
 		}
 
 		// Retry
-		if errDo != nil || s.retryFunc(resp) {
+		if failed {
 			if retriesLeft > 1 {
+				// Get sleep
+				sleep = s.retrySleep(resp, tries, sleep)
+
+				// Get failure reason
+				var reason string
+				if errDo != nil {
+					reason = errDo.Error()
+				} else {
+					reason = "status code is " + strconv.Itoa(resp.StatusCode)
+				}
+
+				// Rewind body before closing the response and sleeping so that, if it can't
+				// be rewound, the last response is returned untouched
+				var body io.ReadCloser
+				if req.Body != nil && req.Body != http.NoBody {
+					if req.GetBody == nil {
+						s.l.Errorf("astikit: sending %s failed and its body can't be rewound, not retrying: %s", nr, reason)
+						break
+					}
+					var err error
+					if body, err = req.GetBody(); err != nil {
+						s.l.Errorf("astikit: sending %s failed (%s) and rewinding its body failed, not retrying: %v", nr, reason, err)
+						break
+					}
+				}
+
 				if errDo == nil {
 					resp.Body.Close()
 				}
-				s.l.Errorf("astikit: sending %s failed, sleeping %s and retrying... (%d retries left): %w", nr, s.retrySleep, retriesLeft-1, errDo)
-				time.Sleep(s.retrySleep)
+				s.l.Errorf("astikit: sending %s failed, sleeping %s and retrying... (%d retries left): %s", nr, sleep, retriesLeft-1, reason)
+				if err := Sleep(req.Context(), sleep); err != nil {
+					if body != nil {
+						body.Close()
+					}
+					return nil, err
+				}
+
+				// Use rewound body
+				if body != nil {
+					req = req.WithContext(req.Context())
+					req.Body = body
+				}
 			}
 			continue
 		}
@@ -197,6 +357,312 @@ func (s *HTTPSender) send(req *http.Request, timeout time.Duration) (*http.Respo
 	return resp, nil
 }
 
+const httpSenderDefaultRetryAfterMax = time.Minute
+
+// retrySleep returns how long to sleep before the nth retry (starting at 1) knowing how long
+// was slept before the previous one
+func (s *HTTPSender) retrySleep(resp *http.Response, retry int, previous time.Duration) time.Duration {
+	// Retry-After header
+	if d, ok := s.retryAfter(resp); ok {
+		limit := s.retrySleepMax
+		if limit <= 0 {
+			limit = httpSenderDefaultRetryAfterMax
+		}
+		if d > limit {
+			d = limit
+		}
+		return d
+	}
+
+	// Backoff
+	d := s.backoff(retry, previous)
+	if s.retrySleepMax > 0 && d > s.retrySleepMax {
+		d = s.retrySleepMax
+	}
+	return d
+}
+
+// retryAfter parses the Retry-After header of 429 and 503 responses, which is either a
+// number of seconds or an HTTP date
+func (s *HTTPSender) retryAfter(resp *http.Response) (time.Duration, bool) {
+	// Check status code
+	if resp == nil || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+
+	// Get header
+	v := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+
+	// Seconds
+	if i, err := strconv.Atoi(v); err == nil {
+		if i < 0 {
+			return 0, false
+		}
+		return time.Duration(i) * time.Second, true
+	}
+
+	// HTTP date
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now()); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// HTTPSenderCircuitBreakerState represents the state of a host's circuit breaker
+type HTTPSenderCircuitBreakerState string
+
+const (
+	// Requests are sent
+	HTTPSenderCircuitBreakerStateClosed HTTPSenderCircuitBreakerState = "closed"
+	// Requests are sent one at a time to probe the host
+	HTTPSenderCircuitBreakerStateHalfOpen HTTPSenderCircuitBreakerState = "half-open"
+	// Requests are rejected
+	HTTPSenderCircuitBreakerStateOpen HTTPSenderCircuitBreakerState = "open"
+)
+
+// HTTPSenderCircuitBreakerOptions represents HTTPSender circuit breaker options
+type HTTPSenderCircuitBreakerOptions struct {
+	// Duration during which an open circuit breaker rejects requests before switching to
+	// half-open. Defaults to 30s.
+	CoolDown time.Duration
+	// Number of consecutive failed requests after which a closed circuit breaker switches to
+	// open. Defaults to 5.
+	FailureThreshold int
+	// Called each time a host's circuit breaker changes state
+	OnStateChange func(host string, from, to HTTPSenderCircuitBreakerState)
+	// Number of consecutive successful requests after which a half-open circuit breaker
+	// switches to closed. Defaults to 1.
+	SuccessThreshold int
+}
+
+// HTTPSenderCircuitBreakerOpenError is returned when a request is rejected because the host's
+// circuit breaker is open
+type HTTPSenderCircuitBreakerOpenError struct {
+	Host string
+	// Time at which the circuit breaker will switch to half-open. Zero if it already has but
+	// a probe request is in progress.
+	RetryAt time.Time
+}
+
+func (err HTTPSenderCircuitBreakerOpenError) Error() string {
+	return fmt.Sprintf("astikit: circuit breaker for host %s is open", err.Host)
+}
+
+type httpSenderCircuitBreaker struct {
+	hosts         map[string]*httpSenderCircuitBreakerHost
+	m             *sync.Mutex // Locks hosts
+	o             HTTPSenderCircuitBreakerOptions
+	statRejected  uint64
+	statTransited uint64
+}
+
+type httpSenderCircuitBreakerHost struct {
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	state     HTTPSenderCircuitBreakerState
+	successes int
+}
+
+func newHTTPSenderCircuitBreaker(o HTTPSenderCircuitBreakerOptions) *httpSenderCircuitBreaker {
+	if o.CoolDown <= 0 {
+		o.CoolDown = 30 * time.Second
+	}
+	if o.FailureThreshold <= 0 {
+		o.FailureThreshold = 5
+	}
+	if o.SuccessThreshold <= 0 {
+		o.SuccessThreshold = 1
+	}
+	return &httpSenderCircuitBreaker{
+		hosts: make(map[string]*httpSenderCircuitBreakerHost),
+		m:     &sync.Mutex{},
+		o:     o,
+	}
+}
+
+func (b *httpSenderCircuitBreaker) host(host string) *httpSenderCircuitBreakerHost {
+	h, ok := b.hosts[host]
+	if !ok {
+		h = &httpSenderCircuitBreakerHost{state: HTTPSenderCircuitBreakerStateClosed}
+		b.hosts[host] = h
+	}
+	return h
+}
+
+// Must be called with the lock held. Returns a func that must be executed once the lock has
+// been released.
+func (b *httpSenderCircuitBreaker) transit(host string, h *httpSenderCircuitBreakerHost, to HTTPSenderCircuitBreakerState) func() {
+	from := h.state
+	h.failures = 0
+	h.probing = false
+	h.state = to
+	h.successes = 0
+	if to == HTTPSenderCircuitBreakerStateOpen {
+		h.openedAt = now()
+	}
+	atomic.AddUint64(&b.statTransited, 1)
+	return func() {
+		if b.o.OnStateChange != nil {
+			b.o.OnStateChange(host, from, to)
+		}
+	}
+}
+
+func (b *httpSenderCircuitBreaker) allow(host string) error {
+	// Lock
+	b.m.Lock()
+	h := b.host(host)
+
+	// Cool down is over
+	var fn func()
+	if h.state == HTTPSenderCircuitBreakerStateOpen && now().Sub(h.openedAt) >= b.o.CoolDown {
+		fn = b.transit(host, h, HTTPSenderCircuitBreakerStateHalfOpen)
+	}
+
+	// Check state
+	var err error
+	switch h.state {
+	case HTTPSenderCircuitBreakerStateHalfOpen:
+		if h.probing {
+			err = HTTPSenderCircuitBreakerOpenError{Host: host}
+		} else {
+			h.probing = true
+		}
+	case HTTPSenderCircuitBreakerStateOpen:
+		err = HTTPSenderCircuitBreakerOpenError{
+			Host:    host,
+			RetryAt: h.openedAt.Add(b.o.CoolDown),
+		}
+	}
+	if err != nil {
+		atomic.AddUint64(&b.statRejected, 1)
+	}
+
+	// Unlock
+	b.m.Unlock()
+
+	// Callback
+	if fn != nil {
+		fn()
+	}
+	return err
+}
+
+func (b *httpSenderCircuitBreaker) abort(host string) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.host(host).probing = false
+}
+
+func (b *httpSenderCircuitBreaker) report(host string, success bool) {
+	// Lock
+	b.m.Lock()
+	h := b.host(host)
+
+	// Update
+	var fn func()
+	switch h.state {
+	case HTTPSenderCircuitBreakerStateClosed:
+		if success {
+			h.failures = 0
+		} else if h.failures++; h.failures >= b.o.FailureThreshold {
+			fn = b.transit(host, h, HTTPSenderCircuitBreakerStateOpen)
+		}
+	case HTTPSenderCircuitBreakerStateHalfOpen:
+		h.probing = false
+		if !success {
+			fn = b.transit(host, h, HTTPSenderCircuitBreakerStateOpen)
+		} else if h.successes++; h.successes >= b.o.SuccessThreshold {
+			fn = b.transit(host, h, HTTPSenderCircuitBreakerStateClosed)
+		}
+	}
+
+	// Unlock
+	b.m.Unlock()
+
+	// Callback
+	if fn != nil {
+		fn()
+	}
+}
+
+func (b *httpSenderCircuitBreaker) count(state HTTPSenderCircuitBreakerState) (c int) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	for _, h := range b.hosts {
+		if h.state == state {
+			c++
+		}
+	}
+	return
+}
+
+// CircuitBreakerState returns the state of the host's circuit breaker. It returns the closed
+// state if the circuit breaker is disabled.
+func (s *HTTPSender) CircuitBreakerState(host string) HTTPSenderCircuitBreakerState {
+	if s.cb == nil {
+		return HTTPSenderCircuitBreakerStateClosed
+	}
+	s.cb.m.Lock()
+	defer s.cb.m.Unlock()
+	if h, ok := s.cb.hosts[host]; ok {
+		return h.state
+	}
+	return HTTPSenderCircuitBreakerStateClosed
+}
+
+// StatOptions returns the circuit breaker stat options. It returns nil if the circuit breaker
+// is disabled.
+func (s *HTTPSender) StatOptions() []StatOptions {
+	if s.cb == nil {
+		return nil
+	}
+	return []StatOptions{
+		{
+			Metadata: &StatMetadata{
+				Description: "Number of hosts whose circuit breaker is half-open",
+				Label:       "Half-open count",
+				Name:        StatNameHalfOpenCount,
+			},
+			Valuer: StatValuerFunc(func(_ time.Duration) any { return s.cb.count(HTTPSenderCircuitBreakerStateHalfOpen) }),
+		},
+		{
+			Metadata: &StatMetadata{
+				Description: "Number of hosts whose circuit breaker is open",
+				Label:       "Open count",
+				Name:        StatNameOpenCount,
+			},
+			Valuer: StatValuerFunc(func(_ time.Duration) any { return s.cb.count(HTTPSenderCircuitBreakerStateOpen) }),
+		},
+		{
+			Metadata: &StatMetadata{
+				Description: "Number of requests rejected by circuit breakers per second",
+				Label:       "Rejected rate",
+				Name:        StatNameRejectedRate,
+				Unit:        "/s",
+			},
+			Valuer: NewAtomicUint64RateStat(&s.cb.statRejected),
+		},
+		{
+			Metadata: &StatMetadata{
+				Description: "Number of circuit breaker state transitions per second",
+				Label:       "Transition rate",
+				Name:        StatNameTransitionRate,
+				Unit:        "/s",
+			},
+			Valuer: NewAtomicUint64RateStat(&s.cb.statTransited),
+		},
+	}
+}
+
 type HTTPSenderHeaderFunc func(h http.Header)
 
 type HTTPSenderInvalidStatusCodeError struct {
@@ -284,7 +750,7 @@ func (s *HTTPSender) SendJSON(o HTTPSendJSONOptions) (err error) {
 
 	// Send request
 	var resp *http.Response
-	if resp, err = s.send(req, timeout); err != nil {
+	if resp, err = s.send(ctx, req, timeout); err != nil {
 		err = fmt.Errorf("astikit: sending request failed: %w", err)
 		return
 	}
@@ -356,25 +822,41 @@ func defaultHTTPResponseFunc(resp *http.Response) (err error) {
 // HTTPDownloader represents an object capable of downloading several HTTP srcs simultaneously
 // and doing stuff to the results
 type HTTPDownloader struct {
+	bl           *httpDownloaderBandwidthLimiter
 	bp           *BufferPool
 	l            *GoroutineLimiter
+	progressFunc HTTPDownloaderProgressFunc
 	responseFunc HTTPResponseFunc
+	resume       bool
 	s            *HTTPSender
 }
 
 // HTTPDownloaderOptions represents HTTPDownloader options
 type HTTPDownloaderOptions struct {
-	Limiter      GoroutineLimiterOptions
+	// Maximum number of bytes per second downloaded by all srcs of all calls combined.
+	// Default is no limit.
+	BandwidthLimit int64
+	Limiter        GoroutineLimiterOptions
+	// Called synchronously each time bytes are downloaded, therefore it shouldn't block
+	ProgressFunc HTTPDownloaderProgressFunc
 	ResponseFunc HTTPResponseFunc
-	Sender       HTTPSenderOptions
+	// If true, DownloadInFile resumes partially written files using range requests, as long as
+	// srcs have not changed since the file was first written, which is checked using their
+	// ETag and Last-Modified headers. Progress is stored next to the file, in a file suffixed
+	// with .resume that only the owner can read and write.
+	Resume bool
+	Sender HTTPSenderOptions
 }
 
 // NewHTTPDownloader creates a new HTTPDownloader
 func NewHTTPDownloader(o HTTPDownloaderOptions) (d *HTTPDownloader) {
 	d = &HTTPDownloader{
+		bl:           newHTTPDownloaderBandwidthLimiter(o.BandwidthLimit),
 		bp:           NewBufferPool(),
 		l:            NewGoroutineLimiter(o.Limiter),
+		progressFunc: o.ProgressFunc,
 		responseFunc: o.ResponseFunc,
+		resume:       o.Resume,
 		s:            NewHTTPSender(o.Sender),
 	}
 	if d.responseFunc == nil {
@@ -389,24 +871,198 @@ func (d *HTTPDownloader) Close() error {
 }
 
 type HTTPDownloaderSrc struct {
-	Body   io.Reader
-	Header http.Header
-	Method string
+	// Maximum number of bytes per second downloaded for this src.
+	// Default is no limit.
+	BandwidthLimit int64
+	Body           io.Reader
+	// Expected hex encoded checksums of the src, computed while downloading it. Empty
+	// checksums are not checked.
+	ExpectedCRC32  string
+	ExpectedMD5    string
+	ExpectedSHA1   string
+	ExpectedSHA256 string
+	Header         http.Header
+	Method         string
+	// Number of parallel range requests the src is split into when downloaded with
+	// DownloadInWriter or DownloadInFile. It's ignored if the server doesn't support range
+	// requests or doesn't provide the src length.
+	// Default is 1
+	Segments int
+	URL      string
+	// Weight of the src for the limiter, which limits the sum of the weights of the srcs
+	// being downloaded in parallel. It can be set to the expected size or cost of the src
+	// to limit by bytes or cost instead of by request count. It's taken into account once
+	// per src, whatever its number of segments, which are all downloaded in parallel once
+	// the src has been allowed by the limiter.
+	// Default is 1
+	Weight int
+}
+
+// HTTPDownloaderValidatorMismatchError is returned when a src has changed between the requests
+// made to download it, which prevents resuming it or stitching its segments
+type HTTPDownloaderValidatorMismatchError struct {
+	Expected string
+	Got      string
+	// Either "ETag" or "Last-Modified"
+	Header string
 	URL    string
 }
 
-// It is the responsibility of the caller to call i.Close()
-type httpDownloaderFunc func(ctx context.Context, idx int, i *BufferPoolItem) error
+func (err HTTPDownloaderValidatorMismatchError) Error() string {
+	return fmt.Sprintf("astikit: %s of %s has changed from %s to %s", err.Header, err.URL, err.Expected, err.Got)
+}
 
-func (d *HTTPDownloader) do(ctx context.Context, fn httpDownloaderFunc, idx int, src HTTPDownloaderSrc) (err error) {
-	// Defaults
-	if src.Method == "" {
-		src.Method = http.MethodGet
+// HTTPDownloaderChecksumMismatchError is returned when the checksum of a downloaded src is not
+// the expected one
+type HTTPDownloaderChecksumMismatchError struct {
+	// Either "crc32", "md5", "sha1" or "sha256"
+	Algorithm string
+	Expected  string
+	Got       string
+	URL       string
+}
+
+func (err HTTPDownloaderChecksumMismatchError) Error() string {
+	return fmt.Sprintf("astikit: %s checksum of %s is %s, expected %s", err.Algorithm, err.URL, err.Got, err.Expected)
+}
+
+type httpDownloaderHasher struct {
+	hs  []httpDownloaderHash
+	url string
+}
+
+type httpDownloaderHash struct {
+	algorithm string
+	expected  string
+	h         hash.Hash
+}
+
+// Returns nil if no checksum is expected
+func newHTTPDownloaderHasher(src HTTPDownloaderSrc) *httpDownloaderHasher {
+	h := &httpDownloaderHasher{url: src.URL}
+	for _, v := range []struct {
+		algorithm string
+		expected  string
+		fn        func() hash.Hash
+	}{
+		{algorithm: "crc32", expected: src.ExpectedCRC32, fn: func() hash.Hash { return crc32.NewIEEE() }},
+		{algorithm: "md5", expected: src.ExpectedMD5, fn: md5.New},
+		{algorithm: "sha1", expected: src.ExpectedSHA1, fn: sha1.New},
+		{algorithm: "sha256", expected: src.ExpectedSHA256, fn: sha256.New},
+	} {
+		if v.expected != "" {
+			h.hs = append(h.hs, httpDownloaderHash{
+				algorithm: v.algorithm,
+				expected:  strings.ToLower(v.expected),
+				h:         v.fn(),
+			})
+		}
+	}
+	if len(h.hs) == 0 {
+		return nil
+	}
+	return h
+}
+
+func (h *httpDownloaderHasher) Write(p []byte) (int, error) {
+	for _, v := range h.hs {
+		v.h.Write(p) //nolint:errcheck
+	}
+	return len(p), nil
+}
+
+func (h *httpDownloaderHasher) verify() error {
+	for _, v := range h.hs {
+		if g := hex.EncodeToString(v.h.Sum(nil)); g != v.expected {
+			return HTTPDownloaderChecksumMismatchError{
+				Algorithm: v.algorithm,
+				Expected:  v.expected,
+				Got:       g,
+				URL:       h.url,
+			}
+		}
+	}
+	return nil
+}
+
+const httpDownloaderResumeSuffix = ".resume"
+
+type httpDownloaderRequest struct {
+	l      *httpDownloaderBandwidthLimiter
+	rng    *httpDownloaderRange
+	src    HTTPDownloaderSrc
+	srcIdx int
+}
+
+type httpDownloaderRange struct {
+	end   int64 // Inclusive
+	info  httpDownloaderInfo
+	start int64
+}
+
+type httpDownloaderInfo struct {
+	AcceptRanges bool   `json:"accept_ranges"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Size         int64  `json:"size"` // -1 if unknown
+	URL          string `json:"url"`
+}
+
+func (i httpDownloaderInfo) hasValidator() bool {
+	return i.ETag != "" || i.LastModified != ""
+}
+
+// Weak ETags can't be used in If-Range headers
+func (i httpDownloaderInfo) ifRange() string {
+	if i.ETag != "" && !strings.HasPrefix(i.ETag, "W/") {
+		return i.ETag
+	}
+	return i.LastModified
+}
+
+func (r httpDownloaderRange) validate(resp *http.Response, url string) error {
+	// Src has changed
+	for _, v := range []struct {
+		e string
+		h string
+	}{
+		{e: r.info.ETag, h: "ETag"},
+		{e: r.info.LastModified, h: "Last-Modified"},
+	} {
+		if g := resp.Header.Get(v.h); v.e != "" && g != "" && v.e != g {
+			return HTTPDownloaderValidatorMismatchError{
+				Expected: v.e,
+				Got:      g,
+				Header:   v.h,
+				URL:      url,
+			}
+		}
+	}
+
+	// Range has been ignored
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("astikit: expected status code %d, got %d", http.StatusPartialContent, resp.StatusCode)
+	}
+	if cr := resp.Header.Get("Content-Range"); !strings.HasPrefix(cr, "bytes "+strconv.FormatInt(r.start, 10)+"-") {
+		return fmt.Errorf("astikit: content range %s doesn't start at %d", cr, r.start)
+	}
+	return nil
+}
+
+// info retrieves src's length and validators without downloading it
+func (d *HTTPDownloader) info(ctx context.Context, src HTTPDownloaderSrc) (i httpDownloaderInfo, err error) {
+	// Only GET srcs can be requested partially
+	i = httpDownloaderInfo{
+		Size: -1,
+		URL:  src.URL,
+	}
+	if src.Method != "" && src.Method != http.MethodGet {
+		return
 	}
 
 	// Create request
 	var r *http.Request
-	if r, err = http.NewRequestWithContext(ctx, src.Method, src.URL, src.Body); err != nil {
+	if r, err = http.NewRequestWithContext(ctx, http.MethodHead, src.URL, nil); err != nil {
 		err = fmt.Errorf("astikit: creating request to %s failed: %w", src.URL, err)
 		return
 	}
@@ -424,181 +1080,956 @@ func (d *HTTPDownloader) do(ctx context.Context, fn httpDownloaderFunc, idx int,
 	}
 	defer resp.Body.Close()
 
-	// Create buffer pool item
-	buf := d.bp.New()
-
 	// Process response
 	if err = d.responseFunc(resp); err != nil {
 		err = fmt.Errorf("astikit: response for request to %s is invalid: %w", src.URL, err)
 		return
 	}
 
-	// Copy body
-	if _, err = Copy(ctx, buf, resp.Body); err != nil {
-		err = fmt.Errorf("astikit: copying body of %s failed: %w", src.URL, err)
-		return
-	}
-
-	// Custom
-	if err = fn(ctx, idx, buf); err != nil {
-		err = fmt.Errorf("astikit: custom callback on %s failed: %w", src.URL, err)
-		return
-	}
+	// Update info
+	i.AcceptRanges = resp.Header.Get("Accept-Ranges") == "bytes"
+	i.ETag = resp.Header.Get("ETag")
+	i.LastModified = resp.Header.Get("Last-Modified")
+	i.Size = resp.ContentLength
 	return
 }
 
-func (d *HTTPDownloader) download(ctx context.Context, srcs []HTTPDownloaderSrc, fn httpDownloaderFunc) (err error) {
-	// Nothing to download
-	if len(srcs) == 0 {
-		return nil
+// segments splits the part of the src starting at start in src.Segments range requests,
+// if possible
+func (d *HTTPDownloader) segments(srcIdx int, src HTTPDownloaderSrc, i httpDownloaderInfo, start int64) (rs []httpDownloaderRequest) {
+	// Src can't be requested partially
+	if !i.AcceptRanges || i.Size < 0 {
+		return []httpDownloaderRequest{{src: src, srcIdx: srcIdx}}
 	}
 
-	// Loop through srcs
-	wg := &sync.WaitGroup{}
-	wg.Add(len(srcs))
-	for idx, src := range srcs {
-		func(idx int, src HTTPDownloaderSrc) {
-			// Update error with ctx
-			if ctx.Err() != nil {
-				err = ctx.Err()
-			}
-
-			// Do nothing if error
-			if err != nil {
-				wg.Done()
-				return
-			}
-
-			// Do
-			//nolint:errcheck
-			d.l.Do(func() {
-				// Task is done
-				defer wg.Done()
+	// Get number of segments
+	n := int64(src.Segments)
+	if n < 1 {
+		n = 1
+	}
+	if l := i.Size - start; l <= 0 {
+		return
+	} else if n > l {
+		n = l
+	}
 
-				// Do
-				if errD := d.do(ctx, fn, idx, src); errD != nil && err == nil {
-					err = errD
-					return
-				}
-			})
-		}(idx, src)
+	// No need to request partially
+	if n == 1 && start == 0 {
+		return []httpDownloaderRequest{{src: src, srcIdx: srcIdx}}
 	}
 
-	// Wait
-	wg.Wait()
+	// Loop through segments
+	l := (i.Size - start) / n
+	for idx := int64(0); idx < n; idx++ {
+		r := &httpDownloaderRange{
+			end:   start + (idx+1)*l - 1,
+			info:  i,
+			start: start + idx*l,
+		}
+		if idx == n-1 {
+			r.end = i.Size - 1
+		}
+		rs = append(rs, httpDownloaderRequest{
+			rng:    r,
+			src:    src,
+			srcIdx: srcIdx,
+		})
+	}
 	return
 }
 
-// DownloadInDirectory downloads in parallel a set of srcs and saves them in a dst directory
-func (d *HTTPDownloader) DownloadInDirectory(ctx context.Context, dst string, srcs ...HTTPDownloaderSrc) error {
-	return d.download(ctx, srcs, func(ctx context.Context, idx int, buf *BufferPoolItem) (err error) {
-		// Make sure to close buffer
-		defer buf.Close()
+// HTTPDownloaderProgress represents the progress of a download call
+type HTTPDownloaderProgress struct {
+	// Number of bytes downloaded for all srcs
+	Done int64
+	// Index of the src that has progressed, among the srcs provided to the download call
+	SrcIdx int
+	// Number of bytes downloaded for the src
+	SrcDone int64
+	// Number of bytes to download for the src, based on Content-Length. -1 if unknown.
+	SrcTotal int64
+	// Number of bytes to download for all srcs. -1 as long as the number of bytes to download
+	// for one of them is unknown.
+	Total int64
+}
 
-		// Make sure destination directory exists
-		if err = os.MkdirAll(dst, DefaultDirMode); err != nil {
-			err = fmt.Errorf("astikit: mkdirall %s failed: %w", dst, err)
-			return
-		}
+// HTTPDownloaderProgressFunc is a function that handles download progress
+type HTTPDownloaderProgressFunc func(p HTTPDownloaderProgress)
 
-		// Create destination file
-		var f *os.File
-		dst := filepath.Join(dst, filepath.Base(srcs[idx].URL))
-		if f, err = os.Create(dst); err != nil {
-			err = fmt.Errorf("astikit: creating %s failed: %w", dst, err)
-			return
-		}
-		defer f.Close()
+type httpDownloaderProgressTracker struct {
+	fn   HTTPDownloaderProgressFunc
+	m    *sync.Mutex // Locks reqs
+	reqs []httpDownloaderProgressRequest
+}
 
-		// Copy buffer
-		if _, err = Copy(ctx, f, buf); err != nil {
-			err = fmt.Errorf("astikit: copying content to %s failed: %w", dst, err)
-			return
-		}
-		return
-	})
+type httpDownloaderProgressRequest struct {
+	done   int64
+	srcIdx int
+	total  int64 // -1 if unknown
 }
 
-// DownloadInWriter downloads in parallel a set of srcs and concatenates them in a writer while
-// maintaining the initial order
-func (d *HTTPDownloader) DownloadInWriter(ctx context.Context, dst io.Writer, srcs ...HTTPDownloaderSrc) error {
-	// Init
-	type chunk struct {
-		buf *BufferPoolItem
-		idx int
+func newHTTPDownloaderProgressTracker(fn HTTPDownloaderProgressFunc, reqs []httpDownloaderRequest) *httpDownloaderProgressTracker {
+	t := &httpDownloaderProgressTracker{
+		fn:   fn,
+		m:    &sync.Mutex{},
+		reqs: make([]httpDownloaderProgressRequest, len(reqs)),
 	}
-	var cs []chunk
-	var m sync.Mutex // Locks cs
-	var requiredIdx int
-
-	// Make sure to close all buffers
-	defer func() {
-		for _, c := range cs {
-			c.buf.Close()
+	for idx, req := range reqs {
+		t.reqs[idx] = httpDownloaderProgressRequest{
+			srcIdx: req.srcIdx,
+			total:  -1,
 		}
-	}()
-
-	// Download
-	return d.download(ctx, srcs, func(ctx context.Context, idx int, buf *BufferPoolItem) (err error) {
-		// Lock
-		m.Lock()
-		defer m.Unlock()
-
-		// Check where to insert chunk
-		var idxInsert = -1
-		for idxChunk := 0; idxChunk < len(cs); idxChunk++ {
-			if idx < cs[idxChunk].idx {
-				idxInsert = idxChunk
-				break
-			}
+		if req.rng != nil {
+			t.reqs[idx].total = req.rng.end - req.rng.start + 1
 		}
+	}
+	return t
+}
 
-		// Create chunk
-		c := chunk{
-			buf: buf,
-			idx: idx,
-		}
+func (t *httpDownloaderProgressTracker) setTotal(idx int, total int64) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.reqs[idx].total = total
+}
 
-		// Add chunk
-		if idxInsert > -1 {
-			cs = append(cs[:idxInsert], append([]chunk{c}, cs[idxInsert:]...)...)
-		} else {
-			cs = append(cs, c)
+func (t *httpDownloaderProgressTracker) add(idx int, n int64) {
+	// Lock
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	// Update
+	t.reqs[idx].done += n
+
+	// Compute progress
+	p := HTTPDownloaderProgress{SrcIdx: t.reqs[idx].srcIdx}
+	for _, r := range t.reqs {
+		p.Done += r.done
+		if p.Total >= 0 {
+			if r.total < 0 {
+				p.Total = -1
+			} else {
+				p.Total += r.total
+			}
 		}
-
-		// Loop through chunks
-		for idxChunk := 0; idxChunk < len(cs); idxChunk++ {
-			// Get chunk
-			c := cs[idxChunk]
-
-			// The chunk should be copied
-			if c.idx == requiredIdx {
-				// Copy chunk content
-				// Do not check error right away since we still want to close the buffer
-				// and remove the chunk
-				_, err = Copy(ctx, dst, c.buf)
-
-				// Close buffer
-				c.buf.Close()
-
-				// Remove chunk
-				requiredIdx++
-				cs = append(cs[:idxChunk], cs[idxChunk+1:]...)
-				idxChunk--
-
-				// Check error
-				if err != nil {
-					err = fmt.Errorf("astikit: copying chunk #%d to dst failed: %w", c.idx, err)
-					return
+		if r.srcIdx == p.SrcIdx {
+			p.SrcDone += r.done
+			if p.SrcTotal >= 0 {
+				if r.total < 0 {
+					p.SrcTotal = -1
+				} else {
+					p.SrcTotal += r.total
 				}
 			}
 		}
-		return
-	})
+	}
+
+	// Callback
+	t.fn(p)
 }
 
-// DownloadInFile downloads in parallel a set of srcs and concatenates them in a dst file while
-// maintaining the initial order
+// httpDownloaderBandwidthLimiter makes sure that no more than limit bytes per second are read
+// on average
+type httpDownloaderBandwidthLimiter struct {
+	limit int64
+	m     *sync.Mutex // Locks next
+	next  time.Time   // Time at which all bytes read so far are allowed
+}
+
+func newHTTPDownloaderBandwidthLimiter(limit int64) *httpDownloaderBandwidthLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &httpDownloaderBandwidthLimiter{
+		limit: limit,
+		m:     &sync.Mutex{},
+	}
+}
+
+// Reads are limited to 1/10th of the limit so that bandwidth is smooth
+func (l *httpDownloaderBandwidthLimiter) maxRead() int {
+	if m := l.limit / 10; m > 0 {
+		return int(m)
+	}
+	return 1
+}
+
+// wait blocks until n more bytes are allowed
+func (l *httpDownloaderBandwidthLimiter) wait(ctx context.Context, n int) error {
+	// Lock
+	l.m.Lock()
+
+	// Update next
+	n0 := now()
+	if l.next.Before(n0) {
+		l.next = n0
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.limit))
+	d := l.next.Sub(n0)
+
+	// Unlock
+	l.m.Unlock()
+
+	// Sleep
+	if d <= 0 {
+		return nil
+	}
+	return Sleep(ctx, d)
+}
+
+type httpDownloaderReader struct {
+	ctx context.Context
+	fn  func(n int)
+	ls  []*httpDownloaderBandwidthLimiter
+	r   io.Reader
+}
+
+func (r *httpDownloaderReader) Read(p []byte) (n int, err error) {
+	// Limit read size
+	for _, l := range r.ls {
+		if m := l.maxRead(); len(p) > m {
+			p = p[:m]
+		}
+	}
+
+	// Read
+	if n, err = r.r.Read(p); n <= 0 {
+		return
+	}
+
+	// Callback
+	if r.fn != nil {
+		r.fn(n)
+	}
+
+	// Wait
+	for _, l := range r.ls {
+		if errW := l.wait(r.ctx, n); errW != nil {
+			return n, errW
+		}
+	}
+	return
+}
+
+// httpDownloaderWriter is the writer the body of a request is streamed into
+type httpDownloaderWriter interface {
+	io.Writer
+	// close is called once the body has been written, with a non-nil error if it has failed
+	close(err error) error
+}
+
+// httpDownloaderFunc returns the writer the body of the request at index idx is streamed into,
+// knowing the size of the body, which is -1 if unknown
+type httpDownloaderFunc func(idx int, size int64) (httpDownloaderWriter, error)
+
+func (d *HTTPDownloader) do(ctx context.Context, fn httpDownloaderFunc, idx int, req httpDownloaderRequest, pt *httpDownloaderProgressTracker) (err error) {
+	// Defaults
+	src := req.src
+	if src.Method == "" {
+		src.Method = http.MethodGet
+	}
+
+	// Create request
+	var r *http.Request
+	if r, err = http.NewRequestWithContext(ctx, src.Method, src.URL, src.Body); err != nil {
+		err = fmt.Errorf("astikit: creating request to %s failed: %w", src.URL, err)
+		return
+	}
+
+	// Copy header
+	for k := range src.Header {
+		r.Header.Set(k, src.Header.Get(k))
+	}
+
+	// Range
+	if req.rng != nil {
+		r.Header.Set("Range", "bytes="+strconv.FormatInt(req.rng.start, 10)+"-"+strconv.FormatInt(req.rng.end, 10))
+		if v := req.rng.info.ifRange(); v != "" {
+			r.Header.Set("If-Range", v)
+		}
+	}
+
+	// Send request
+	var resp *http.Response
+	if resp, err = d.s.Send(r); err != nil {
+		err = fmt.Errorf("astikit: sending request to %s failed: %w", src.URL, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	// Process response
+	if err = d.responseFunc(resp); err != nil {
+		err = fmt.Errorf("astikit: response for request to %s is invalid: %w", src.URL, err)
+		return
+	}
+
+	// Validate range
+	if req.rng != nil {
+		if err = req.rng.validate(resp, src.URL); err != nil {
+			err = fmt.Errorf("astikit: validating range response for request to %s failed: %w", src.URL, err)
+			return
+		}
+	}
+
+	// Update progress
+	if pt != nil && req.rng == nil {
+		pt.setTotal(idx, resp.ContentLength)
+	}
+
+	// Create reader
+	br := &httpDownloaderReader{
+		ctx: ctx,
+		r:   resp.Body,
+	}
+	for _, l := range []*httpDownloaderBandwidthLimiter{d.bl, req.l} {
+		if l != nil {
+			br.ls = append(br.ls, l)
+		}
+	}
+	if pt != nil {
+		br.fn = func(n int) { pt.add(idx, int64(n)) }
+	}
+
+	// Checksums of srcs downloaded with range requests are computed once their chunks are
+	// stitched
+	var rd io.Reader = br
+	var h *httpDownloaderHasher
+	if req.rng == nil {
+		if h = newHTTPDownloaderHasher(src); h != nil {
+			rd = io.TeeReader(br, h)
+		}
+	}
+
+	// Get body size
+	// A zero content length is not trusted since custom clients may not set it
+	size := resp.ContentLength
+	if size == 0 {
+		size = -1
+	}
+	if req.rng != nil {
+		size = req.rng.end - req.rng.start + 1
+	}
+
+	// Get writer
+	var w httpDownloaderWriter
+	if w, err = fn(idx, size); err != nil {
+		err = fmt.Errorf("astikit: getting writer of %s failed: %w", src.URL, err)
+		return
+	}
+
+	// Make sure to close writer
+	defer func() {
+		if errC := w.close(err); errC != nil && err == nil {
+			err = fmt.Errorf("astikit: closing writer of %s failed: %w", src.URL, errC)
+		}
+	}()
+
+	// Stream body
+	if _, err = Copy(ctx, w, rd); err != nil {
+		err = fmt.Errorf("astikit: copying body of %s failed: %w", src.URL, err)
+		return
+	}
+
+	// Verify checksums
+	if h != nil {
+		if err = h.verify(); err != nil {
+			err = fmt.Errorf("astikit: verifying checksums of %s failed: %w", src.URL, err)
+			return
+		}
+	}
+	return
+}
+
+func (d *HTTPDownloader) download(ctx context.Context, reqs []httpDownloaderRequest, fn httpDownloaderFunc) (err error) {
+	// Nothing to download
+	if len(reqs) == 0 {
+		return nil
+	}
+
+	// Create bandwidth limiters
+	ls := make(map[int]*httpDownloaderBandwidthLimiter)
+	for idx := range reqs {
+		if reqs[idx].src.BandwidthLimit <= 0 {
+			continue
+		}
+		if _, ok := ls[reqs[idx].srcIdx]; !ok {
+			ls[reqs[idx].srcIdx] = newHTTPDownloaderBandwidthLimiter(reqs[idx].src.BandwidthLimit)
+		}
+		reqs[idx].l = ls[reqs[idx].srcIdx]
+	}
+
+	// Create progress tracker
+	var pt *httpDownloaderProgressTracker
+	if d.progressFunc != nil {
+		pt = newHTTPDownloaderProgressTracker(d.progressFunc, reqs)
+	}
+
+	// Group requests by src so that the limiter takes the weight of a src into account only
+	// once, whatever its number of segments
+	var groups [][]int // Indexes of the requests of each src
+	for idx := range reqs {
+		if idx == 0 || reqs[idx].srcIdx != reqs[idx-1].srcIdx {
+			groups = append(groups, []int{})
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], idx)
+	}
+
+	// Loop through groups
+	var m sync.Mutex // Locks err
+	wg := &sync.WaitGroup{}
+	wg.Add(len(groups))
+	for _, g := range groups {
+		func(g []int) {
+			// Update error with ctx
+			m.Lock()
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+
+			// Do nothing if error
+			if err != nil {
+				m.Unlock()
+				wg.Done()
+				return
+			}
+			m.Unlock()
+
+			// Do
+			if errD := d.l.DoWeighted(ctx, reqs[g[0]].src.Weight, func() {
+				// Task is done
+				defer wg.Done()
+
+				// Segments of the src are downloaded in parallel
+				wgg := &sync.WaitGroup{}
+				wgg.Add(len(g))
+				for _, idx := range g {
+					go func(idx int) {
+						// Request is done
+						defer wgg.Done()
+
+						// Do
+						if errD := d.do(ctx, fn, idx, reqs[idx], pt); errD != nil {
+							m.Lock()
+							if err == nil {
+								err = errD
+							}
+							m.Unlock()
+						}
+					}(idx)
+				}
+				wgg.Wait()
+			}); errD != nil {
+				m.Lock()
+				if err == nil {
+					err = errD
+				}
+				m.Unlock()
+				wg.Done()
+			}
+		}(g)
+	}
+
+	// Wait
+	wg.Wait()
+	return
+}
+
+// DownloadInDirectory downloads in parallel a set of srcs and saves them in a dst directory
+// with DefaultFileMode
+func (d *HTTPDownloader) DownloadInDirectory(ctx context.Context, dst string, srcs ...HTTPDownloaderSrc) error {
+	// Get requests
+	var reqs []httpDownloaderRequest
+	for idx, src := range srcs {
+		reqs = append(reqs, httpDownloaderRequest{
+			src:    src,
+			srcIdx: idx,
+		})
+	}
+
+	// Download
+	return d.download(ctx, reqs, func(idx int, size int64) (httpDownloaderWriter, error) {
+		// Make sure destination directory exists
+		if err := os.MkdirAll(dst, DefaultDirMode); err != nil {
+			return nil, fmt.Errorf("astikit: mkdirall %s failed: %w", dst, err)
+		}
+
+		// Create destination file
+		dst := filepath.Join(dst, filepath.Base(srcs[idx].URL))
+		f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, DefaultFileMode)
+		if err != nil {
+			return nil, fmt.Errorf("astikit: creating %s failed: %w", dst, err)
+		}
+		return httpDownloaderFileWriter{File: f}, nil
+	})
+}
+
+type httpDownloaderFileWriter struct {
+	*os.File
+}
+
+func (w httpDownloaderFileWriter) close(err error) error {
+	// Close file
+	errC := w.File.Close()
+
+	// Remove partially written file
+	if err != nil {
+		os.Remove(w.Name())
+		return nil
+	}
+
+	// Check close error
+	if errC != nil {
+		return fmt.Errorf("astikit: closing %s failed: %w", w.Name(), errC)
+	}
+	return nil
+}
+
+// DownloadInWriter downloads in parallel a set of srcs and concatenates them in a writer while
+// maintaining the initial order. Bodies of srcs and segments received before the previous ones
+// are done are buffered in memory, which may represent most of the download when srcs are split
+// in segments. DownloadInFile doesn't have this memory cost.
+func (d *HTTPDownloader) DownloadInWriter(ctx context.Context, dst io.Writer, srcs ...HTTPDownloaderSrc) error {
+	return d.downloadInWriter(ctx, dst, d.requests(ctx, srcs))
+}
+
+// requests splits srcs in requests
+func (d *HTTPDownloader) requests(ctx context.Context, srcs []HTTPDownloaderSrc) (reqs []httpDownloaderRequest) {
+	for idx, src := range srcs {
+		// No need to split src
+		if src.Segments <= 1 {
+			reqs = append(reqs, httpDownloaderRequest{
+				src:    src,
+				srcIdx: idx,
+			})
+			continue
+		}
+
+		// Get info
+		// If it fails, src is downloaded with a plain GET request
+		i, err := d.info(ctx, src)
+		if err != nil {
+			d.s.l.Errorf("astikit: getting info of %s failed, not splitting it: %v", src.URL, err)
+		}
+
+		// Split src
+		reqs = append(reqs, d.segments(idx, src, i, 0)...)
+	}
+	return
+}
+
+func (d *HTTPDownloader) downloadInWriter(ctx context.Context, dst io.Writer, reqs []httpDownloaderRequest) error {
+	// Create stitcher
+	st := newHTTPDownloaderStitcher(dst, reqs, d.bp)
+	defer st.close()
+
+	// Download
+	return d.download(ctx, reqs, func(idx int, size int64) (httpDownloaderWriter, error) {
+		return httpDownloaderStitcherWriter{idx: idx, st: st}, nil
+	})
+}
+
+// newHTTPDownloaderStitchHashers creates the hashers of srcs downloaded with range requests,
+// whose checksums can only be computed once their chunks are stitched, indexed by src index,
+// as well as the index of the last request of each src
+func newHTTPDownloaderStitchHashers(reqs []httpDownloaderRequest) (hs map[int]*httpDownloaderHasher, lastIdxs map[int]int) {
+	hs = make(map[int]*httpDownloaderHasher)
+	lastIdxs = make(map[int]int)
+	for idx, req := range reqs {
+		if req.rng == nil {
+			continue
+		}
+		if _, ok := hs[req.srcIdx]; !ok {
+			if h := newHTTPDownloaderHasher(req.src); h != nil {
+				hs[req.srcIdx] = h
+			}
+		}
+		lastIdxs[req.srcIdx] = idx
+	}
+	return
+}
+
+// httpDownloaderStitcher writes the bodies of requests in a writer while maintaining their order.
+// The body of the first request that is not done yet is written as soon as it's received whereas
+// bodies of the following requests are buffered until it's done, so that the writer always
+// contains the beginning of the download.
+type httpDownloaderStitcher struct {
+	bp          *BufferPool
+	bufs        map[int]*BufferPoolItem
+	dones       map[int]bool
+	hs          map[int]*httpDownloaderHasher // Indexed by src index
+	lastIdxs    map[int]int                   // Index of the last request of each src
+	m           *sync.Mutex                   // Locks bufs, dones, hs and requiredIdx
+	requiredIdx int
+	reqs        []httpDownloaderRequest
+	w           io.Writer
+}
+
+func newHTTPDownloaderStitcher(w io.Writer, reqs []httpDownloaderRequest, bp *BufferPool) *httpDownloaderStitcher {
+	s := &httpDownloaderStitcher{
+		bp:    bp,
+		bufs:  make(map[int]*BufferPoolItem),
+		dones: make(map[int]bool),
+		m:     &sync.Mutex{},
+		reqs:  reqs,
+		w:     w,
+	}
+	s.hs, s.lastIdxs = newHTTPDownloaderStitchHashers(reqs)
+	return s
+}
+
+func (s *httpDownloaderStitcher) close() {
+	// Lock
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// Close buffers
+	for idx, buf := range s.bufs {
+		buf.Close()
+		delete(s.bufs, idx)
+	}
+}
+
+func (s *httpDownloaderStitcher) write(idx int, p []byte) (int, error) {
+	// Lock
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// Previous requests are not done yet
+	if idx != s.requiredIdx {
+		buf, ok := s.bufs[idx]
+		if !ok {
+			buf = s.bp.New()
+			s.bufs[idx] = buf
+		}
+		return buf.Write(p)
+	}
+	return s.copy(idx, p)
+}
+
+// copy writes p in the writer, as well as in the hasher of the src of the request at index idx
+func (s *httpDownloaderStitcher) copy(idx int, p []byte) (n int, err error) {
+	// Get writer
+	var w = s.w
+	if h, ok := s.hs[s.reqs[idx].srcIdx]; ok {
+		w = io.MultiWriter(s.w, h)
+	}
+
+	// Write
+	if n, err = w.Write(p); err != nil {
+		err = fmt.Errorf("astikit: copying chunk #%d to dst failed: %w", idx, err)
+		return
+	}
+	return
+}
+
+func (s *httpDownloaderStitcher) done(idx int, err error) error {
+	// Following requests are not written anymore since the download has failed
+	if err != nil {
+		return nil
+	}
+
+	// Lock
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// Update done
+	s.dones[idx] = true
+
+	// Loop through requests that can be written
+	for ; s.requiredIdx < len(s.reqs); s.requiredIdx++ {
+		// Write buffered body
+		if buf, ok := s.bufs[s.requiredIdx]; ok {
+			// Do not check error right away since we still want to close the buffer
+			_, err = s.copy(s.requiredIdx, buf.Bytes())
+
+			// Close buffer
+			buf.Close()
+			delete(s.bufs, s.requiredIdx)
+
+			// Check error
+			if err != nil {
+				return err
+			}
+		}
+
+		// Request is not done yet, the rest of its body will be written as soon as it's received
+		if !s.dones[s.requiredIdx] {
+			break
+		}
+
+		// Verify checksums once the last chunk of the src has been written
+		req := s.reqs[s.requiredIdx]
+		if h, ok := s.hs[req.srcIdx]; ok && s.lastIdxs[req.srcIdx] == s.requiredIdx {
+			if err = h.verify(); err != nil {
+				return fmt.Errorf("astikit: verifying checksums of %s failed: %w", req.src.URL, err)
+			}
+		}
+	}
+	return nil
+}
+
+type httpDownloaderStitcherWriter struct {
+	idx int
+	st  *httpDownloaderStitcher
+}
+
+func (w httpDownloaderStitcherWriter) Write(p []byte) (int, error) {
+	return w.st.write(w.idx, p)
+}
+
+func (w httpDownloaderStitcherWriter) close(err error) error {
+	return w.st.done(w.idx, err)
+}
+
+type httpDownloaderFile interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// httpDownloaderFileStitcher writes the bodies of requests directly at their offset in a file,
+// as soon as it's known, i.e. as soon as the sizes of all previous requests are known. Until
+// then, bodies are buffered in memory.
+type httpDownloaderFileStitcher struct {
+	bp          *BufferPool
+	bufs        map[int]*BufferPoolItem
+	dones       []bool
+	f           httpDownloaderFile
+	hs          map[int]*httpDownloaderHasher // Indexed by src index
+	lastIdxs    map[int]int                   // Index of the last request of each src
+	m           *sync.Mutex                   // Locks everything but bp, f, offset, onStitch and reqs
+	offset      int64                         // Offset of the first request in f
+	offsets     []int64                       // -1 if unknown
+	onStitch    func(n int64) error
+	received    []int64 // Number of bytes received for each request
+	requiredIdx int     // Index of the first request that is not done
+	reqs        []httpDownloaderRequest
+	sizes       []int64 // -1 if unknown
+}
+
+func newHTTPDownloaderFileStitcher(f httpDownloaderFile, offset int64, reqs []httpDownloaderRequest, bp *BufferPool, onStitch func(n int64) error) *httpDownloaderFileStitcher {
+	s := &httpDownloaderFileStitcher{
+		bp:       bp,
+		bufs:     make(map[int]*BufferPoolItem),
+		dones:    make([]bool, len(reqs)),
+		f:        f,
+		m:        &sync.Mutex{},
+		offset:   offset,
+		offsets:  make([]int64, len(reqs)),
+		onStitch: onStitch,
+		received: make([]int64, len(reqs)),
+		reqs:     reqs,
+		sizes:    make([]int64, len(reqs)),
+	}
+	s.hs, s.lastIdxs = newHTTPDownloaderStitchHashers(reqs)
+	for idx, req := range reqs {
+		s.sizes[idx] = -1
+		if req.rng != nil {
+			s.sizes[idx] = req.rng.end - req.rng.start + 1
+		}
+	}
+	s.locate() //nolint:errcheck
+	return s
+}
+
+func (s *httpDownloaderFileStitcher) close() {
+	// Lock
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// Close buffers
+	for idx, buf := range s.bufs {
+		buf.Close()
+		delete(s.bufs, idx)
+	}
+}
+
+// locate updates offsets based on sizes, and writes the buffered bodies of requests whose
+// offset has become known. It must be called with s locked.
+func (s *httpDownloaderFileStitcher) locate() error {
+	o := s.offset
+	for idx := range s.reqs {
+		// Offset is unknown
+		if o < 0 {
+			s.offsets[idx] = -1
+			continue
+		}
+		s.offsets[idx] = o
+
+		// Write buffered body
+		if buf, ok := s.bufs[idx]; ok {
+			// Do not check error right away since we still want to close the buffer
+			_, err := s.f.WriteAt(buf.Bytes(), o)
+
+			// Close buffer
+			buf.Close()
+			delete(s.bufs, idx)
+
+			// Check error
+			if err != nil {
+				return fmt.Errorf("astikit: writing chunk #%d at offset %d failed: %w", idx, o, err)
+			}
+		}
+
+		// Update offset
+		if s.sizes[idx] < 0 {
+			o = -1
+		} else {
+			o += s.sizes[idx]
+		}
+	}
+	return nil
+}
+
+// stitched returns the number of bytes that have been written contiguously from the offset. It
+// must be called with s locked.
+func (s *httpDownloaderFileStitcher) stitched() int64 {
+	if s.requiredIdx >= len(s.reqs) {
+		var n int64
+		for _, size := range s.sizes {
+			n += size
+		}
+		return n
+	}
+	return s.offsets[s.requiredIdx] - s.offset + s.received[s.requiredIdx]
+}
+
+func (s *httpDownloaderFileStitcher) writer(idx int, size int64) (httpDownloaderWriter, error) {
+	// Lock
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// Update size
+	if s.sizes[idx] < 0 && size >= 0 {
+		s.sizes[idx] = size
+		if err := s.locate(); err != nil {
+			return nil, err
+		}
+	}
+	return httpDownloaderFileStitcherWriter{idx: idx, st: s}, nil
+}
+
+func (s *httpDownloaderFileStitcher) write(idx int, p []byte) (n int, err error) {
+	// Lock
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// Body must not overflow into the next request
+	if s.sizes[idx] >= 0 && s.received[idx]+int64(len(p)) > s.sizes[idx] {
+		err = fmt.Errorf("astikit: chunk #%d is bigger than %d bytes", idx, s.sizes[idx])
+		return
+	}
+
+	// Offset is unknown
+	if s.offsets[idx] < 0 {
+		buf, ok := s.bufs[idx]
+		if !ok {
+			buf = s.bp.New()
+			s.bufs[idx] = buf
+		}
+		n, err = buf.Write(p)
+		s.received[idx] += int64(n)
+		return
+	}
+
+	// Write
+	o := s.offsets[idx] + s.received[idx]
+	n, err = s.f.WriteAt(p, o)
+	s.received[idx] += int64(n)
+	if err != nil {
+		err = fmt.Errorf("astikit: writing chunk #%d at offset %d failed: %w", idx, o, err)
+		return
+	}
+	return
+}
+
+func (s *httpDownloaderFileStitcher) done(idx int, err error) error {
+	// Following requests are not stitched anymore since the download has failed
+	if err != nil {
+		return nil
+	}
+
+	// Lock
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// Update done
+	s.dones[idx] = true
+
+	// Check size
+	if s.sizes[idx] < 0 {
+		s.sizes[idx] = s.received[idx]
+		if err = s.locate(); err != nil {
+			return err
+		}
+	} else if s.received[idx] != s.sizes[idx] {
+		return fmt.Errorf("astikit: received %d bytes for chunk #%d, expected %d", s.received[idx], idx, s.sizes[idx])
+	}
+
+	// Loop through requests that can be stitched
+	requiredIdx := s.requiredIdx
+	for ; s.requiredIdx < len(s.reqs) && s.dones[s.requiredIdx]; s.requiredIdx++ {
+		// Get hasher
+		req := s.reqs[s.requiredIdx]
+		h, ok := s.hs[req.srcIdx]
+		if !ok {
+			continue
+		}
+
+		// Hash chunk
+		if _, err = io.Copy(h, io.NewSectionReader(s.f, s.offsets[s.requiredIdx], s.sizes[s.requiredIdx])); err != nil {
+			return fmt.Errorf("astikit: hashing chunk #%d failed: %w", s.requiredIdx, err)
+		}
+
+		// Verify checksums once the last chunk of the src has been stitched
+		if s.lastIdxs[req.srcIdx] == s.requiredIdx {
+			if err = h.verify(); err != nil {
+				return fmt.Errorf("astikit: verifying checksums of %s failed: %w", req.src.URL, err)
+			}
+		}
+	}
+
+	// Callback
+	if s.onStitch != nil && s.requiredIdx > requiredIdx {
+		if err = s.onStitch(s.stitched()); err != nil {
+			return fmt.Errorf("astikit: stitch callback failed: %w", err)
+		}
+	}
+	return nil
+}
+
+type httpDownloaderFileStitcherWriter struct {
+	idx int
+	st  *httpDownloaderFileStitcher
+}
+
+func (w httpDownloaderFileStitcherWriter) Write(p []byte) (int, error) {
+	return w.st.write(w.idx, p)
+}
+
+func (w httpDownloaderFileStitcherWriter) close(err error) error {
+	return w.st.done(w.idx, err)
+}
+
+// downloadInFile downloads reqs in f starting at offset, and returns the number of bytes that
+// have been written contiguously from offset, even if it fails
+func (d *HTTPDownloader) downloadInFile(ctx context.Context, f httpDownloaderFile, offset int64, reqs []httpDownloaderRequest, onStitch func(n int64) error) (n int64, err error) {
+	// Create stitcher
+	st := newHTTPDownloaderFileStitcher(f, offset, reqs, d.bp, onStitch)
+	defer st.close()
+
+	// Download
+	err = d.download(ctx, reqs, st.writer)
+
+	// Get number of bytes stitched
+	st.m.Lock()
+	n = st.stitched()
+	st.m.Unlock()
+	return
+}
+
+// DownloadInFile downloads in parallel a set of srcs and concatenates them in a dst file while
+// maintaining the initial order. Bodies are written at their offset in the file as soon as it's
+// known, which is as soon as the sizes of all previous srcs and segments are known, so that they
+// don't need to be buffered in memory. dst is created with DefaultFileMode.
 func (d *HTTPDownloader) DownloadInFile(ctx context.Context, dst string, srcs ...HTTPDownloaderSrc) (err error) {
 	// Make sure destination directory exists
 	if err = os.MkdirAll(filepath.Dir(dst), DefaultDirMode); err != nil {
@@ -606,16 +2037,161 @@ func (d *HTTPDownloader) DownloadInFile(ctx context.Context, dst string, srcs ..
 		return
 	}
 
+	// Resume
+	if d.resume {
+		return d.downloadInFileWithResume(ctx, dst, srcs)
+	}
+
 	// Create destination file
 	var f *os.File
-	if f, err = os.Create(dst); err != nil {
+	if f, err = os.OpenFile(dst, os.O_CREATE|os.O_RDWR|os.O_TRUNC, DefaultFileMode); err != nil {
 		err = fmt.Errorf("astikit: creating %s failed: %w", dst, err)
 		return
 	}
 	defer f.Close()
 
-	// Download in writer
-	return d.DownloadInWriter(ctx, f, srcs...)
+	// Download in file
+	_, err = d.downloadInFile(ctx, f, 0, d.requests(ctx, srcs), nil)
+	return
+}
+
+// httpDownloaderResume is the content of the resume file
+type httpDownloaderResume struct {
+	// Number of bytes of dst that have been downloaded contiguously from its start
+	Done  int64                `json:"done"`
+	Infos []httpDownloaderInfo `json:"infos"`
+}
+
+func (d *HTTPDownloader) downloadInFileWithResume(ctx context.Context, dst string, srcs []HTTPDownloaderSrc) (err error) {
+	// Get infos
+	is := make([]httpDownloaderInfo, len(srcs))
+	for idx, src := range srcs {
+		// If it fails, src is downloaded with a plain GET request and can't be resumed
+		var errI error
+		if is[idx], errI = d.info(ctx, src); errI != nil {
+			d.s.l.Errorf("astikit: getting info of %s failed, not resuming it: %v", src.URL, errI)
+		}
+	}
+
+	// Get offset
+	// Bytes following what has been downloaded contiguously may have been written as well but
+	// can't be trusted
+	var offset int64
+	if done, ok := d.canResume(dst, is); ok {
+		if fi, errS := os.Stat(dst); errS == nil {
+			offset = fi.Size()
+			if done < offset {
+				offset = done
+			}
+		}
+	}
+
+	// Loop through srcs
+	var reqs []httpDownloaderRequest
+	var located bool
+	var start int64
+	for idx, src := range srcs {
+		// Offset has already been located
+		if located {
+			reqs = append(reqs, d.segments(idx, src, is[idx], 0)...)
+			continue
+		}
+
+		// Src has already been downloaded
+		if is[idx].Size >= 0 && offset >= start+is[idx].Size {
+			start += is[idx].Size
+			continue
+		}
+
+		// Src can't be resumed, or its checksums couldn't be computed if it were
+		located = true
+		if !is[idx].AcceptRanges || is[idx].Size < 0 || newHTTPDownloaderHasher(src) != nil {
+			offset = start
+		}
+		reqs = append(reqs, d.segments(idx, src, is[idx], offset-start)...)
+	}
+
+	// Everything has already been downloaded
+	if !located {
+		offset = start
+	}
+
+	// Write resume file
+	rp := dst + httpDownloaderResumeSuffix
+	writeResume := func(done int64) error {
+		b, err := json.Marshal(httpDownloaderResume{Done: done, Infos: is})
+		if err != nil {
+			return fmt.Errorf("astikit: marshaling failed: %w", err)
+		}
+		// Resume file contains URLs and validators of srcs which may be sensitive
+		if err = os.WriteFile(rp, b, 0600); err != nil {
+			return fmt.Errorf("astikit: writing %s failed: %w", rp, err)
+		}
+		return nil
+	}
+	if err = writeResume(offset); err != nil {
+		return
+	}
+
+	// Open destination file
+	var f *os.File
+	if f, err = os.OpenFile(dst, os.O_CREATE|os.O_RDWR, DefaultFileMode); err != nil {
+		err = fmt.Errorf("astikit: opening %s failed: %w", dst, err)
+		return
+	}
+	defer f.Close()
+
+	// Remove what needs to be downloaded again
+	if err = f.Truncate(offset); err != nil {
+		err = fmt.Errorf("astikit: truncating %s failed: %w", dst, err)
+		return
+	}
+
+	// Download in file
+	// Progress is saved each time a chunk is stitched so that it can be resumed even if the
+	// process is killed
+	var n int64
+	if n, err = d.downloadInFile(ctx, f, offset, reqs, func(n int64) error { return writeResume(offset + n) }); err != nil {
+		// Only keep what has been downloaded contiguously
+		if errT := f.Truncate(offset + n); errT == nil {
+			writeResume(offset + n) //nolint:errcheck
+		}
+		return
+	}
+
+	// Remove resume file
+	if err = os.Remove(rp); err != nil {
+		err = fmt.Errorf("astikit: removing %s failed: %w", rp, err)
+		return
+	}
+	return
+}
+
+// canResume checks whether srcs have not changed since dst was first written, and returns the
+// number of bytes of dst that have been downloaded contiguously
+func (d *HTTPDownloader) canResume(dst string, is []httpDownloaderInfo) (int64, bool) {
+	// Read resume file
+	b, err := os.ReadFile(dst + httpDownloaderResumeSuffix)
+	if err != nil {
+		return 0, false
+	}
+
+	// Unmarshal
+	var r httpDownloaderResume
+	if err = json.Unmarshal(b, &r); err != nil {
+		return 0, false
+	}
+
+	// Compare
+	if len(r.Infos) != len(is) {
+		return 0, false
+	}
+	for idx := range is {
+		if !is[idx].hasValidator() || is[idx] != r.Infos[idx] {
+			return 0, false
+		}
+	}
+	return r.Done, true
 }
 
 // HTTPMiddleware represents an HTTP middleware
@@ -3,15 +3,27 @@ package astikit
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"hash/crc32"
 	"io"
+	"math"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -62,10 +74,14 @@ func (err mockedNetError) Temporary() bool { return false }
 
 type mockedHTTPBody struct {
 	closed bool
+	r      io.Reader
 }
 
-func (b *mockedHTTPBody) Read([]byte) (int, error) {
-	return 0, nil
+func (b *mockedHTTPBody) Read(p []byte) (int, error) {
+	if b.r == nil {
+		return 0, nil
+	}
+	return b.r.Read(p)
 }
 
 func (b *mockedHTTPBody) Close() error {
@@ -293,6 +309,308 @@ func TestHTTPSender(t *testing.T) {
 	}
 }
 
+func TestHTTPSenderRetry(t *testing.T) {
+	// Backoffs
+	if e, g := time.Second, HTTPSenderConstantBackoff(time.Second)(3, 0); e != g {
+		t.Fatalf("expected %v, got %v", e, g)
+	}
+	eb := HTTPSenderExponentialBackoff(time.Second, 0)
+	for i, e := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if g := eb(i+1, 0); e != g {
+			t.Fatalf("expected %v, got %v", e, g)
+		}
+	}
+	for _, r := range []int{64, 1000, 1 << 20} {
+		if e, g := time.Duration(math.MaxInt64), eb(r, 0); e != g {
+			t.Fatalf("expected %v, got %v", e, g)
+		}
+	}
+	jb := HTTPSenderDecorrelatedJitterBackoff(time.Second)
+	for i := 0; i < 10; i++ {
+		if g := jb(i+1, 2*time.Second); g < time.Second || g >= 6*time.Second {
+			t.Fatalf("expected [1s, 6s), got %v", g)
+		}
+	}
+	if g := jb(1, math.MaxInt64); g < time.Second {
+		t.Fatalf("expected >= 1s, got %v", g)
+	}
+
+	// Retry-After and cap
+	defer MockNow(func() time.Time { return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC) }).Close()
+	s := NewHTTPSender(HTTPSenderOptions{})
+	for _, v := range []struct {
+		code int
+		d    time.Duration
+		h    string
+		ok   bool
+	}{
+		{code: http.StatusTooManyRequests, d: 2 * time.Second, h: "2", ok: true},
+		{code: http.StatusServiceUnavailable, d: 3 * time.Second, h: "Wed, 01 Jan 2020 00:00:03 GMT", ok: true},
+		{code: http.StatusServiceUnavailable, h: "invalid"},
+		{code: http.StatusInternalServerError, h: "2"},
+	} {
+		d, ok := s.retryAfter(&http.Response{Header: http.Header{"Retry-After": []string{v.h}}, StatusCode: v.code})
+		if ok != v.ok {
+			t.Fatalf("expected %v, got %v", v.ok, ok)
+		}
+		if d != v.d {
+			t.Fatalf("expected %v, got %v", v.d, d)
+		}
+	}
+	for _, v := range []struct {
+		d   time.Duration
+		h   string
+		max time.Duration
+	}{
+		{d: httpSenderDefaultRetryAfterMax, h: "86400"},
+		{d: httpSenderDefaultRetryAfterMax, h: "Sat, 01 Jan 2100 00:00:00 GMT"},
+		{d: 2 * time.Second, h: "2"},
+		{d: time.Second, h: "2", max: time.Second},
+		{d: time.Hour, h: "86400", max: time.Hour},
+		{d: 5 * time.Second, max: time.Hour},
+		{d: time.Second, max: time.Second},
+	} {
+		d := NewHTTPSender(HTTPSenderOptions{
+			RetrySleep:    5 * time.Second,
+			RetrySleepMax: v.max,
+		}).retrySleep(&http.Response{Header: http.Header{"Retry-After": []string{v.h}}, StatusCode: http.StatusTooManyRequests}, 1, 0)
+		if d != v.d {
+			t.Fatalf("expected %v, got %v", v.d, d)
+		}
+	}
+	var c int
+	s = NewHTTPSender(HTTPSenderOptions{
+		Client: mockedHTTPClient(func(req *http.Request) (resp *http.Response, err error) {
+			c++
+			return &http.Response{
+				Body:       &mockedHTTPBody{},
+				Header:     http.Header{"Retry-After": []string{"3600"}},
+				StatusCode: http.StatusTooManyRequests,
+			}, nil
+		}),
+		RetryMax:      1,
+		RetrySleepMax: time.Millisecond,
+	})
+	if _, err := s.Send(&http.Request{}); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e := 2; c != e {
+		t.Fatalf("expected %v, got %v", e, c)
+	}
+
+	// Network errors
+	for _, v := range []struct {
+		err   error
+		tries int
+	}{
+		{err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}, tries: 3},
+		{err: &net.DNSError{IsTimeout: true}, tries: 1},
+		{err: errors.New("test"), tries: 1},
+	} {
+		c = 0
+		s = NewHTTPSender(HTTPSenderOptions{
+			Client: mockedHTTPClient(func(req *http.Request) (resp *http.Response, err error) {
+				c++
+				return nil, v.err
+			}),
+			RetryMax: 2,
+		})
+		if _, err := s.Send(&http.Request{}); !errors.Is(err, v.err) {
+			t.Fatalf("expected %+v, got %+v", v.err, err)
+		}
+		if c != v.tries {
+			t.Fatalf("expected %v, got %v", v.tries, c)
+		}
+	}
+
+	// Body is rewound
+	var bs []string
+	s = NewHTTPSender(HTTPSenderOptions{
+		Client: mockedHTTPClient(func(req *http.Request) (resp *http.Response, err error) {
+			b, err := io.ReadAll(req.Body)
+			if err != nil {
+				return nil, err
+			}
+			bs = append(bs, string(b))
+			return &http.Response{Body: &mockedHTTPBody{}, StatusCode: http.StatusInternalServerError}, nil
+		}),
+		RetryMax: 2,
+	})
+	req, err := http.NewRequest(http.MethodPost, "https://domain.com", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if _, err = s.Send(req); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e := []string{"body", "body", "body"}; !reflect.DeepEqual(e, bs) {
+		t.Fatalf("expected %+v, got %+v", e, bs)
+	}
+
+	// Body can't be rewound
+	bs = []string{}
+	s = NewHTTPSender(HTTPSenderOptions{
+		Backoff: HTTPSenderConstantBackoff(time.Hour),
+		Client: mockedHTTPClient(func(req *http.Request) (resp *http.Response, err error) {
+			b, err := io.ReadAll(req.Body)
+			if err != nil {
+				return nil, err
+			}
+			bs = append(bs, string(b))
+			return &http.Response{Body: &mockedHTTPBody{r: strings.NewReader("response")}, StatusCode: http.StatusInternalServerError}, nil
+		}),
+		RetryMax: 2,
+	})
+	req.GetBody = nil
+	req.Body = io.NopCloser(strings.NewReader("body"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := s.Send(req.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e := []string{"body"}; !reflect.DeepEqual(e, bs) {
+		t.Fatalf("expected %+v, got %+v", e, bs)
+	}
+	if e, g := http.StatusInternalServerError, resp.StatusCode; e != g {
+		t.Fatalf("expected %v, got %v", e, g)
+	}
+	if resp.Body.(*mockedHTTPBody).closed {
+		t.Fatal("expected body not to be closed")
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e, g := "response", string(b); e != g {
+		t.Fatalf("expected %v, got %v", e, g)
+	}
+}
+
+func TestHTTPSenderCircuitBreaker(t *testing.T) {
+	n := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer MockNow(func() time.Time { return n }).Close()
+
+	var code, c int
+	type transition struct {
+		from, to HTTPSenderCircuitBreakerState
+		host     string
+	}
+	var ts []transition
+	s := NewHTTPSender(HTTPSenderOptions{
+		CircuitBreaker: &HTTPSenderCircuitBreakerOptions{
+			CoolDown:         time.Minute,
+			FailureThreshold: 2,
+			OnStateChange: func(host string, from, to HTTPSenderCircuitBreakerState) {
+				ts = append(ts, transition{from: from, host: host, to: to})
+			},
+		},
+		Client: mockedHTTPClient(func(req *http.Request) (resp *http.Response, err error) {
+			c++
+			return &http.Response{Body: &mockedHTTPBody{}, StatusCode: code}, nil
+		}),
+		RetryMax: 5,
+	})
+	req, err := http.NewRequest(http.MethodGet, "https://domain.com", nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+
+	// Open
+	code = http.StatusInternalServerError
+	var oe HTTPSenderCircuitBreakerOpenError
+	if _, err = s.Send(req); !errors.As(err, &oe) {
+		t.Fatalf("expected HTTPSenderCircuitBreakerOpenError, got %+v", err)
+	}
+	if e := (HTTPSenderCircuitBreakerOpenError{Host: "domain.com", RetryAt: n.Add(time.Minute)}); !reflect.DeepEqual(e, oe) {
+		t.Fatalf("expected %+v, got %+v", e, oe)
+	}
+	if e := 2; c != e {
+		t.Fatalf("expected %v, got %v", e, c)
+	}
+	if e, g := HTTPSenderCircuitBreakerStateOpen, s.CircuitBreakerState("domain.com"); e != g {
+		t.Fatalf("expected %v, got %v", e, g)
+	}
+	if e, g := HTTPSenderCircuitBreakerStateClosed, s.CircuitBreakerState("other.com"); e != g {
+		t.Fatalf("expected %v, got %v", e, g)
+	}
+	if _, err = s.Send(req); !errors.As(err, &oe) {
+		t.Fatalf("expected HTTPSenderCircuitBreakerOpenError, got %+v", err)
+	}
+	if e := 2; c != e {
+		t.Fatalf("expected %v, got %v", e, c)
+	}
+
+	// Half-open and open again
+	n = n.Add(time.Minute)
+	if _, err = s.Send(req); !errors.As(err, &oe) {
+		t.Fatalf("expected HTTPSenderCircuitBreakerOpenError, got %+v", err)
+	}
+	if e := 3; c != e {
+		t.Fatalf("expected %v, got %v", e, c)
+	}
+
+	// Half-open and closed
+	n = n.Add(time.Minute)
+	code = http.StatusOK
+	if _, err = s.Send(req); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e, g := HTTPSenderCircuitBreakerStateClosed, s.CircuitBreakerState("domain.com"); e != g {
+		t.Fatalf("expected %v, got %v", e, g)
+	}
+	if e := []transition{
+		{from: HTTPSenderCircuitBreakerStateClosed, host: "domain.com", to: HTTPSenderCircuitBreakerStateOpen},
+		{from: HTTPSenderCircuitBreakerStateOpen, host: "domain.com", to: HTTPSenderCircuitBreakerStateHalfOpen},
+		{from: HTTPSenderCircuitBreakerStateHalfOpen, host: "domain.com", to: HTTPSenderCircuitBreakerStateOpen},
+		{from: HTTPSenderCircuitBreakerStateOpen, host: "domain.com", to: HTTPSenderCircuitBreakerStateHalfOpen},
+		{from: HTTPSenderCircuitBreakerStateHalfOpen, host: "domain.com", to: HTTPSenderCircuitBreakerStateClosed},
+	}; !reflect.DeepEqual(e, ts) {
+		t.Fatalf("expected %+v, got %+v", e, ts)
+	}
+
+	// Stats
+	vs := make(map[string]any)
+	for _, o := range s.StatOptions() {
+		vs[o.Metadata.Name] = o.Valuer.(StatValuer).Value(time.Second)
+	}
+	if e := map[string]any{
+		StatNameHalfOpenCount:  0,
+		StatNameOpenCount:      0,
+		StatNameRejectedRate:   3.0,
+		StatNameTransitionRate: 5.0,
+	}; !reflect.DeepEqual(e, vs) {
+		t.Fatalf("expected %+v, got %+v", e, vs)
+	}
+	if o := NewHTTPSender(HTTPSenderOptions{}).StatOptions(); o != nil {
+		t.Fatalf("expected nil, got %+v", o)
+	}
+
+	// Caller cancellations are aborts whereas the sender's timeout expiring is a failure
+	s = NewHTTPSender(HTTPSenderOptions{
+		CircuitBreaker: &HTTPSenderCircuitBreakerOptions{FailureThreshold: 1},
+		Client: mockedHTTPClient(func(req *http.Request) (resp *http.Response, err error) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		}),
+		Timeout: 10 * time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond, cancel)
+	if _, err = s.Send(req.WithContext(ctx)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %+v", err)
+	}
+	if e, g := HTTPSenderCircuitBreakerStateClosed, s.CircuitBreakerState("domain.com"); e != g {
+		t.Fatalf("expected %v, got %v", e, g)
+	}
+	if _, err = s.Send(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %+v", err)
+	}
+	if e, g := HTTPSenderCircuitBreakerStateOpen, s.CircuitBreakerState("domain.com"); e != g {
+		t.Fatalf("expected %v, got %v", e, g)
+	}
+}
+
 func TestHTTPDownloader(t *testing.T) {
 	// Get temp dir
 	dir := t.TempDir()
@@ -359,6 +677,458 @@ func TestHTTPDownloader(t *testing.T) {
 	checkFile(t, p, "/path/to/1/path/to/2/path/to/3")
 }
 
+func TestHTTPDownloaderRanges(t *testing.T) {
+	// Create server
+	var m sync.Mutex
+	cs := map[string]string{
+		"/a":        "0123456789",
+		"/b":        "abcdefghijklmnopqrstuvwxyz",
+		"/changing": "changing",
+		"/nohead":   "nohead",
+	}
+	etags := map[string]string{}
+	var cut, fail bool
+	var rs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		defer m.Unlock()
+		if r.Method == http.MethodGet {
+			rs = append(rs, r.URL.Path+" "+r.Header.Get("Range"))
+		}
+		if (fail && r.URL.Path == "/b" && r.Method == http.MethodGet) || (r.URL.Path == "/nohead" && r.Method == http.MethodHead) {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		etag := `"` + r.URL.Path + etags[r.URL.Path] + `"`
+		if r.URL.Path == "/changing" {
+			etag = `"` + r.Method + `"`
+		}
+		rw.Header().Set("ETag", etag)
+		if rng := r.Header.Get("Range"); cut && r.URL.Path == "/b" && r.Method == http.MethodGet && (rng == "" || strings.HasPrefix(rng, "bytes=0-")) {
+			// Connection is cut in the middle of the body of the src or of its first segment
+			if rng == "" {
+				rw.Header().Set("Content-Length", strconv.Itoa(len(cs[r.URL.Path])))
+				rw.WriteHeader(http.StatusOK)
+			} else {
+				end, _ := strconv.Atoi(strings.TrimPrefix(rng, "bytes=0-"))
+				rw.Header().Set("Content-Length", strconv.Itoa(end+1))
+				rw.Header().Set("Content-Range", "bytes 0-"+strconv.Itoa(end)+"/"+strconv.Itoa(len(cs[r.URL.Path])))
+				rw.WriteHeader(http.StatusPartialContent)
+			}
+			rw.Write([]byte(cs[r.URL.Path][:5])) //nolint:errcheck
+			rw.(http.Flusher).Flush()
+			if c, _, err := rw.(http.Hijacker).Hijack(); err == nil {
+				c.Close()
+			}
+			return
+		}
+		http.ServeContent(rw, r, "", time.Time{}, strings.NewReader(cs[r.URL.Path]))
+	}))
+	defer srv.Close()
+	reset := func() []string {
+		m.Lock()
+		defer m.Unlock()
+		sort.Strings(rs)
+		o := rs
+		rs = []string{}
+		return o
+	}
+
+	// Create downloader
+	d := NewHTTPDownloader(HTTPDownloaderOptions{
+		Limiter: GoroutineLimiterOptions{Max: 2},
+		Resume:  true,
+	})
+	defer d.Close()
+
+	// Segments
+	w := &bytes.Buffer{}
+	if err := d.DownloadInWriter(context.Background(), w, HTTPDownloaderSrc{Segments: 3, URL: srv.URL + "/b"}); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e, g := cs["/b"], w.String(); e != g {
+		t.Fatalf("expected %s, got %s", e, g)
+	}
+	if e, g := []string{"/b bytes=0-7", "/b bytes=16-25", "/b bytes=8-15"}, reset(); !reflect.DeepEqual(e, g) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+
+	// Src changes between requests
+	var ve HTTPDownloaderValidatorMismatchError
+	if err := d.DownloadInWriter(context.Background(), w, HTTPDownloaderSrc{Segments: 2, URL: srv.URL + "/changing"}); !errors.As(err, &ve) {
+		t.Fatalf("expected HTTPDownloaderValidatorMismatchError, got %+v", err)
+	}
+	reset()
+
+	// Interrupted download
+	dir := t.TempDir()
+	p := filepath.Join(dir, "f")
+	srcs := []HTTPDownloaderSrc{{URL: srv.URL + "/a"}, {URL: srv.URL + "/b"}}
+	fail = true
+	if err := d.DownloadInFile(context.Background(), p, srcs...); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	checkFile(t, p, cs["/a"])
+	reset()
+
+	// Resume between srcs
+	fail = false
+	if err := d.DownloadInFile(context.Background(), p, srcs...); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	checkFile(t, p, cs["/a"]+cs["/b"])
+	if e, g := []string{"/b "}, reset(); !reflect.DeepEqual(e, g) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	if _, err := os.Stat(p + httpDownloaderResumeSuffix); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected os.ErrNotExist, got %+v", err)
+	}
+
+	// Connection is cut in the middle of a src
+	os.Remove(p)
+	cut = true
+	if err := d.DownloadInFile(context.Background(), p, srcs...); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	checkFile(t, p, cs["/a"]+cs["/b"][:5])
+	reset()
+
+	// Resume inside a src
+	cut = false
+	if err := d.DownloadInFile(context.Background(), p, srcs...); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	checkFile(t, p, cs["/a"]+cs["/b"])
+	if e, g := []string{"/b bytes=5-25"}, reset(); !reflect.DeepEqual(e, g) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+
+	// Src has changed
+	os.Remove(p)
+	cut = true
+	if err := d.DownloadInFile(context.Background(), p, srcs...); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	checkFile(t, p, cs["/a"]+cs["/b"][:5])
+	reset()
+	cut = false
+	etags["/b"] = "2"
+	if err := d.DownloadInFile(context.Background(), p, srcs...); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	checkFile(t, p, cs["/a"]+cs["/b"])
+	if e, g := []string{"/a ", "/b "}, reset(); !reflect.DeepEqual(e, g) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+
+	// HEAD request fails
+	w.Reset()
+	if err := d.DownloadInWriter(context.Background(), w, HTTPDownloaderSrc{Segments: 2, URL: srv.URL + "/nohead"}); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e, g := cs["/nohead"], w.String(); e != g {
+		t.Fatalf("expected %s, got %s", e, g)
+	}
+	if err := d.DownloadInFile(context.Background(), p, HTTPDownloaderSrc{URL: srv.URL + "/nohead"}); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	checkFile(t, p, cs["/nohead"])
+	if e, g := []string{"/nohead ", "/nohead "}, reset(); !reflect.DeepEqual(e, g) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+
+	// Connection is cut in the middle of the first segment while the following ones are done
+	os.Remove(p)
+	cut = true
+	srcs = []HTTPDownloaderSrc{{URL: srv.URL + "/a"}, {Segments: 3, URL: srv.URL + "/b"}}
+	if err := d.DownloadInFile(context.Background(), p, srcs...); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	checkFile(t, p, cs["/a"]+cs["/b"][:5])
+	if e, g := []string{"/a ", "/b bytes=0-7", "/b bytes=16-25", "/b bytes=8-15"}, reset(); !reflect.DeepEqual(e, g) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	b, err := os.ReadFile(p + httpDownloaderResumeSuffix)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	var r httpDownloaderResume
+	if err = json.Unmarshal(b, &r); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e, g := int64(15), r.Done; e != g {
+		t.Fatalf("expected %v, got %v", e, g)
+	}
+	for _, v := range []struct {
+		mode os.FileMode
+		p    string
+	}{
+		{mode: 0600, p: p + httpDownloaderResumeSuffix},
+		{mode: DefaultFileMode, p: p},
+	} {
+		fi, err := os.Stat(v.p)
+		if err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+		// Umask may remove permissions
+		if g := fi.Mode().Perm(); g&^v.mode != 0 {
+			t.Fatalf("expected %s to be at most %s, got %s", v.p, v.mode, g)
+		}
+	}
+	cut = false
+	if err = d.DownloadInFile(context.Background(), p, srcs...); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	checkFile(t, p, cs["/a"]+cs["/b"])
+	if e, g := []string{"/b bytes=12-18", "/b bytes=19-25", "/b bytes=5-11"}, reset(); !reflect.DeepEqual(e, g) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+
+	// Bytes following what has been downloaded contiguously are not trusted
+	if err = os.WriteFile(p+httpDownloaderResumeSuffix, b, 0666); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if err = d.DownloadInFile(context.Background(), p, srcs...); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	checkFile(t, p, cs["/a"]+cs["/b"])
+	if e, g := []string{"/b bytes=12-18", "/b bytes=19-25", "/b bytes=5-11"}, reset(); !reflect.DeepEqual(e, g) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+}
+
+func TestHTTPDownloaderInFileWithoutBuffering(t *testing.T) {
+	// Create server
+	c := "abcdefghijklmnopqrstuvwxyz"
+	release := make(chan bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// First segment is blocked
+		if strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") {
+			<-release
+		}
+		http.ServeContent(rw, r, "", time.Time{}, strings.NewReader(c))
+	}))
+	defer srv.Close()
+
+	// Download
+	d := NewHTTPDownloader(HTTPDownloaderOptions{})
+	defer d.Close()
+	p := filepath.Join(t.TempDir(), "f")
+	errC := make(chan error)
+	go func() {
+		errC <- d.DownloadInFile(context.Background(), p, HTTPDownloaderSrc{Segments: 3, URL: srv.URL})
+	}()
+
+	// Following segments are written at their offset before the first one is done
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		if b, err := os.ReadFile(p); err == nil && len(b) == len(c) && string(b[8:]) == c[8:] {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("expected following segments to be written")
+		}
+	}
+	close(release)
+	if err := <-errC; err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	checkFile(t, p, c)
+}
+
+func TestHTTPDownloaderWeight(t *testing.T) {
+	// Create server
+	cs := map[string]string{
+		"/a": "0123456789",
+		"/b": "abcdefghijklmnopqrstuvwxyz",
+	}
+	var m sync.Mutex
+	var inFlight, maxInFlight int
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			m.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			m.Unlock()
+
+			// Wait for the other segments
+			for start := time.Now(); time.Since(start) < time.Second; time.Sleep(time.Millisecond) {
+				m.Lock()
+				ok := maxInFlight >= 3
+				m.Unlock()
+				if ok {
+					break
+				}
+			}
+
+			defer func() {
+				m.Lock()
+				inFlight--
+				m.Unlock()
+			}()
+		}
+		http.ServeContent(rw, r, "", time.Time{}, strings.NewReader(cs[r.URL.Path]))
+	}))
+	defer srv.Close()
+
+	// Segments of a src are limited as a whole
+	d := NewHTTPDownloader(HTTPDownloaderOptions{Limiter: GoroutineLimiterOptions{Max: 1}})
+	defer d.Close()
+	w := &bytes.Buffer{}
+	if err := d.DownloadInWriter(context.Background(), w,
+		HTTPDownloaderSrc{Segments: 3, URL: srv.URL + "/b"},
+		HTTPDownloaderSrc{URL: srv.URL + "/a"},
+	); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e, g := cs["/b"]+cs["/a"], w.String(); e != g {
+		t.Fatalf("expected %s, got %s", e, g)
+	}
+	if e, g := 3, maxInFlight; e != g {
+		t.Fatalf("expected %v, got %v", e, g)
+	}
+}
+
+func TestHTTPDownloaderProgressAndBandwidthLimit(t *testing.T) {
+	// Create server
+	cs := map[string]string{
+		"/a": strings.Repeat("a", 2000),
+		"/b": strings.Repeat("b", 3000),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		http.ServeContent(rw, r, "", time.Time{}, strings.NewReader(cs[r.URL.Path]))
+	}))
+	defer srv.Close()
+
+	// Progress
+	var m sync.Mutex
+	var ps []HTTPDownloaderProgress
+	d := NewHTTPDownloader(HTTPDownloaderOptions{
+		BandwidthLimit: 10000,
+		Limiter:        GoroutineLimiterOptions{Max: 2},
+		ProgressFunc: func(p HTTPDownloaderProgress) {
+			m.Lock()
+			defer m.Unlock()
+			ps = append(ps, p)
+		},
+	})
+	defer d.Close()
+	srcs := []HTTPDownloaderSrc{{URL: srv.URL + "/a"}, {URL: srv.URL + "/b"}}
+	st := time.Now()
+	if err := d.DownloadInDirectory(context.Background(), t.TempDir(), srcs...); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if g := time.Since(st); g < 400*time.Millisecond {
+		t.Fatalf("expected >= 400ms, got %s", g)
+	}
+	last := make(map[int]HTTPDownloaderProgress)
+	var done int64
+	for _, p := range ps {
+		if p.Done < done {
+			t.Fatalf("expected >= %d, got %d", done, p.Done)
+		}
+		done = p.Done
+		last[p.SrcIdx] = p
+	}
+	if e, g := (HTTPDownloaderProgress{Done: 5000, SrcIdx: ps[len(ps)-1].SrcIdx, SrcDone: ps[len(ps)-1].SrcTotal, SrcTotal: ps[len(ps)-1].SrcTotal, Total: 5000}), ps[len(ps)-1]; e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	for idx, l := range []int64{2000, 3000} {
+		if e, g := l, last[idx].SrcDone; e != g {
+			t.Fatalf("expected %d, got %d", e, g)
+		}
+		if e, g := l, last[idx].SrcTotal; e != g {
+			t.Fatalf("expected %d, got %d", e, g)
+		}
+	}
+
+	// Segments
+	ps = []HTTPDownloaderProgress{}
+	w := &bytes.Buffer{}
+	if err := d.DownloadInWriter(context.Background(), w, HTTPDownloaderSrc{Segments: 2, URL: srv.URL + "/a"}); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e, g := (HTTPDownloaderProgress{Done: 2000, SrcDone: 2000, SrcTotal: 2000, Total: 2000}), ps[len(ps)-1]; e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+
+	// Per src bandwidth limit
+	d2 := NewHTTPDownloader(HTTPDownloaderOptions{Limiter: GoroutineLimiterOptions{Max: 2}})
+	defer d2.Close()
+	st = time.Now()
+	if err := d2.DownloadInWriter(context.Background(), w, HTTPDownloaderSrc{BandwidthLimit: 10000, URL: srv.URL + "/a"}, HTTPDownloaderSrc{URL: srv.URL + "/b"}); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if g := time.Since(st); g < 150*time.Millisecond {
+		t.Fatalf("expected >= 150ms, got %s", g)
+	}
+}
+
+func TestHTTPDownloaderChecksums(t *testing.T) {
+	// Create server
+	c := "abcdefghijklmnopqrstuvwxyz"
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		http.ServeContent(rw, r, "", time.Time{}, strings.NewReader(c))
+	}))
+	defer srv.Close()
+
+	// Get checksums
+	crc := crc32.ChecksumIEEE([]byte(c))
+	md5Sum := md5.Sum([]byte(c))
+	sha1Sum := sha1.Sum([]byte(c))
+	sha256Sum := sha256.Sum256([]byte(c))
+	src := HTTPDownloaderSrc{
+		ExpectedCRC32:  hex.EncodeToString([]byte{byte(crc >> 24), byte(crc >> 16), byte(crc >> 8), byte(crc)}),
+		ExpectedMD5:    hex.EncodeToString(md5Sum[:]),
+		ExpectedSHA1:   strings.ToUpper(hex.EncodeToString(sha1Sum[:])),
+		ExpectedSHA256: hex.EncodeToString(sha256Sum[:]),
+	}
+
+	// Create downloader
+	d := NewHTTPDownloader(HTTPDownloaderOptions{})
+	defer d.Close()
+
+	// Valid checksums
+	dir := t.TempDir()
+	src.URL = srv.URL + "/a"
+	if err := d.DownloadInDirectory(context.Background(), dir, src); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	checkFile(t, filepath.Join(dir, "a"), c)
+	src.Segments = 3
+	w := &bytes.Buffer{}
+	if err := d.DownloadInWriter(context.Background(), w, src); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e, g := c, w.String(); e != g {
+		t.Fatalf("expected %s, got %s", e, g)
+	}
+
+	// Invalid checksums
+	var me HTTPDownloaderChecksumMismatchError
+	src.ExpectedMD5 = "invalid"
+	if err := d.DownloadInWriter(context.Background(), w, src); !errors.As(err, &me) {
+		t.Fatalf("expected HTTPDownloaderChecksumMismatchError, got %+v", err)
+	}
+	if e, g := (HTTPDownloaderChecksumMismatchError{Algorithm: "md5", Expected: "invalid", Got: hex.EncodeToString(md5Sum[:]), URL: src.URL}), me; e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	src.ExpectedMD5 = ""
+	src.ExpectedSHA256 = "invalid"
+	src.Segments = 0
+	src.URL = srv.URL + "/b"
+	if err := d.DownloadInDirectory(context.Background(), dir, src); !errors.As(err, &me) {
+		t.Fatalf("expected HTTPDownloaderChecksumMismatchError, got %+v", err)
+	}
+	if e, g := "sha256", me.Algorithm; e != g {
+		t.Fatalf("expected %s, got %s", e, g)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected os.ErrNotExist, got %+v", err)
+	}
+}
+
 func TestProxyPool(t *testing.T) {
 	_, err := NewProxyPool(ProxyPoolOptions{URLs: []string{":"}})
 	if err == nil {
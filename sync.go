@@ -2,10 +2,16 @@ package astikit
 
 import (
 	"bytes"
+	"container/heap"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -13,7 +19,26 @@ import (
 
 // Stat names
 const (
-	StatNameWorkRatio = "astikit.work.ratio"
+	StatNameAcquisitionRate = "astikit.acquisition.rate"
+	StatNameActiveCount     = "astikit.active.count"
+	StatNameActiveWeight    = "astikit.active.weight"
+	StatNameDiscardedCount  = "astikit.discarded.count"
+	StatNameDroppedCount    = "astikit.dropped.count"
+	StatNameHalfOpenCount   = "astikit.half.open.count"
+	StatNameHoldAvg         = "astikit.hold.avg"
+	StatNameLatencyAvg      = "astikit.latency.avg"
+	StatNameLatencyMax      = "astikit.latency.max"
+	StatNameLatencyP50      = "astikit.latency.p50"
+	StatNameLatencyP95      = "astikit.latency.p95"
+	StatNameLatencyP99      = "astikit.latency.p99"
+	StatNameOpenCount       = "astikit.open.count"
+	StatNameProcessedRate   = "astikit.processed.rate"
+	StatNameQueueLength     = "astikit.queue.length"
+	StatNameRejectedRate    = "astikit.rejected.rate"
+	StatNameTransitionRate  = "astikit.transition.rate"
+	StatNameWaitAvg         = "astikit.wait.avg"
+	StatNameWaitingCount    = "astikit.waiting.count"
+	StatNameWorkRatio       = "astikit.work.ratio"
 )
 
 // Chan constants
@@ -25,21 +50,48 @@ const (
 	ChanAddStrategyNoBlock = "no.block"
 	ChanOrderFIFO          = "fifo"
 	ChanOrderFILO          = "filo"
+	// Funcs with the highest priority are processed first, and funcs with the same
+	// priority are processed in FIFO order. See ChanAddWithPriority
+	ChanOrderPriority = "priority"
+	// When the chan is full, Add() blocks until there's room
+	ChanOverflowStrategyBlock = "block"
+	// When the chan is full, the func being added is dropped
+	ChanOverflowStrategyDropNewest = "drop.newest"
+	// When the chan is full, the pending func that would be processed last is dropped in
+	// ChanOrderPriority order, and the first added pending func is dropped otherwise
+	ChanOverflowStrategyDropOldest = "drop.oldest"
+)
+
+// Chan errors
+var (
+	ErrChanDropped = errors.New("astikit: func has been dropped")
+	ErrChanFull    = errors.New("astikit: chan is full")
+	ErrChanStopped = errors.New("astikit: chan is stopped")
 )
 
 // Chan is an object capable of executing funcs in a specific order while controlling the conditions
 // in which adding new funcs is blocking
 // Check out ChanOptions for detailed options
 type Chan struct {
+	busyKeys         map[string]bool // Locked by c
 	cancel           context.CancelFunc
-	c                *sync.Cond
+	c                *sync.Cond // Locks q
 	ctx              context.Context
-	fs               []func()
+	l                SeverityLogger
 	mc               *sync.Mutex // Locks ctx
-	mf               *sync.Mutex // Locks fs
 	o                ChanOptions
+	q                chanQueue
+	room             *sync.Cond // Locks q as well
 	running          uint32
+	scheduled        *chanTimerHeap // Locked by c
+	seq              uint64
+	statDiscarded    uint64
+	statDropped      uint64
+	statLatencies    *chanLatencies
+	statProcessed    uint64
 	statWorkDuration *AtomicDuration
+	timer            *time.Timer // Locked by c
+	timerAt          time.Time   // Locked by c
 }
 
 // ChanOptions are Chan options
@@ -47,22 +99,48 @@ type ChanOptions struct {
 	// Determines the conditions in which Add() blocks. See constants with pattern ChanAddStrategy*
 	// Default is ChanAddStrategyNoBlock
 	AddStrategy string
+	// Logger is used to log recovered panics
+	Logger StdLogger
+	// If > 0, maximum number of pending funcs. See OverflowStrategy to determine what happens
+	// when the chan is full.
+	MaxLen int
 	// Order in which the funcs will be processed. See constants with pattern ChanOrder*
 	// Default is ChanOrderFIFO
 	Order string
+	// Determines what happens when adding a func to a full chan. See constants with pattern
+	// ChanOverflowStrategy*. TryAdd() ignores it and returns ErrChanFull instead.
+	// Default is ChanOverflowStrategyBlock
+	OverflowStrategy string
+	// If set, panics happening in funcs are recovered, logged and provided to PanicHandler,
+	// and the chan keeps processing funcs. By default panics are not recovered.
+	PanicHandler ChanPanicHandler
+	// When order is ChanOrderPriority and PriorityAging is > 0, pending funcs gain one priority
+	// level every PriorityAging so that low priority funcs are not starved
+	PriorityAging time.Duration
 	// By default the funcs not yet processed when the context is cancelled are dropped.
-	// If "ProcessAll" is true,  ALL funcs are processed even after the context is cancelled.
+	// If "ProcessAll" is true,  ALL funcs are processed even after the context is cancelled,
+	// including scheduled funcs which are processed once they're due.
 	// However, no funcs can be added after the context is cancelled
 	ProcessAll bool
+	// Number of goroutines processing funcs concurrently. Funcs added with the same
+	// key (see ChanAddWithKey) are never processed concurrently and are processed in order.
+	// Default is 1
+	Workers int
 }
 
 // NewChan creates a new Chan
 func NewChan(o ChanOptions) *Chan {
+	m := &sync.Mutex{}
 	return &Chan{
-		c:                sync.NewCond(&sync.Mutex{}),
+		busyKeys:         make(map[string]bool),
+		c:                sync.NewCond(m),
+		l:                AdaptStdLogger(o.Logger),
 		mc:               &sync.Mutex{},
-		mf:               &sync.Mutex{},
 		o:                o,
+		q:                newChanQueue(o),
+		room:             sync.NewCond(m),
+		scheduled:        &chanTimerHeap{},
+		statLatencies:    newChanLatencies(),
 		statWorkDuration: NewAtomicDuration(0),
 	}
 }
@@ -88,57 +166,129 @@ func (c *Chan) Start(ctx context.Context) {
 
 			// Signal
 			c.c.L.Lock()
-			c.c.Signal()
+			c.c.Broadcast()
+			c.room.Broadcast()
 			c.c.L.Unlock()
 		}()
 
-		// Loop
-		for {
-			// Lock cond here in case a func is added between retrieving l and doing the if on it
-			c.c.L.Lock()
+		// Start additional workers
+		wg := &sync.WaitGroup{}
+		for idx := 1; idx < c.o.Workers; idx++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.work()
+			}()
+		}
 
-			// Get number of funcs in buffer
-			c.mf.Lock()
-			l := len(c.fs)
-			c.mf.Unlock()
-
-			// Only return if context has been cancelled and:
-			//   - the user wants to drop funcs that has not yet been processed
-			//   - the buffer is empty otherwise
-			c.mc.Lock()
-			if c.ctx.Err() != nil && (!c.o.ProcessAll || l == 0) {
-				c.mc.Unlock()
-				c.c.L.Unlock()
-				return
-			}
-			c.mc.Unlock()
+		// Work
+		c.work()
 
-			// No funcs in buffer
-			if l == 0 {
-				c.c.Wait()
-				c.c.L.Unlock()
-				continue
+		// Wait for additional workers
+		wg.Wait()
+	}
+}
+
+func (c *Chan) isBusy(i *chanItem) bool {
+	return i.key != "" && c.busyKeys[i.key]
+}
+
+func (c *Chan) work() {
+	for {
+		// Lock cond here in case a func is added between retrieving l and doing the if on it
+		c.c.L.Lock()
+
+		// Add scheduled funcs that are due to the buffer
+		c.promote()
+
+		// Get number of funcs in buffer
+		l := c.q.len()
+
+		// Only return if context has been cancelled and:
+		//   - the user wants to drop funcs that has not yet been processed
+		//   - the buffer is empty and there are no scheduled funcs otherwise
+		if c.isStopped() && (!c.o.ProcessAll || (l == 0 && c.scheduled.Len() == 0)) {
+			// Funcs that won't be processed are done with an error so that nobody waits
+			// for them forever
+			c.discard(ErrChanStopped)
+			c.c.L.Unlock()
+			return
+		}
+
+		// Remove next func from buffer, skipping funcs whose key is busy
+		var i *chanItem
+		if l > 0 {
+			if len(c.busyKeys) > 0 {
+				i = c.q.pop(c.isBusy)
+			} else {
+				i = c.q.pop(nil)
 			}
+		}
+
+		// No func to process
+		if i == nil {
+			c.armTimer()
+			c.c.Wait()
 			c.c.L.Unlock()
+			continue
+		}
+		if i.key != "" {
+			c.busyKeys[i.key] = true
+		}
+		c.room.Signal()
+		c.c.L.Unlock()
 
-			// Get first func
-			c.mf.Lock()
-			fn := c.fs[0]
-			c.mf.Unlock()
+		// Update latency
+		c.statLatencies.add(now().Sub(i.addedAt))
 
-			// Execute func
-			n := time.Now()
-			fn()
-			c.statWorkDuration.Add(time.Since(n))
+		// Execute func
+		n := now()
+		err := c.exec(i)
+		c.statWorkDuration.Add(now().Sub(n))
+		atomic.AddUint64(&c.statProcessed, 1)
 
-			// Remove first func
-			c.mf.Lock()
-			c.fs = c.fs[1:]
-			c.mf.Unlock()
+		// Release key
+		if i.key != "" {
+			c.c.L.Lock()
+			delete(c.busyKeys, i.key)
+			c.c.Broadcast()
+			c.c.L.Unlock()
 		}
+		i.done(err)
+	}
+}
+
+func (c *Chan) exec(i *chanItem) (err error) {
+	// Recover panics
+	if c.o.PanicHandler != nil {
+		defer func() {
+			if p := recover(); p != nil {
+				e := ChanPanicError{
+					Stack: debug.Stack(),
+					Value: p,
+				}
+				c.l.Errorf("%s\n%s", e, e.Stack)
+				c.o.PanicHandler(e)
+				err = e
+			}
+		}()
 	}
+	return i.fn()
 }
 
+// ChanPanicError represents a panic recovered while processing a func
+type ChanPanicError struct {
+	Stack []byte
+	Value any
+}
+
+func (e ChanPanicError) Error() string {
+	return fmt.Sprintf("astikit: func panicked: %v", e.Value)
+}
+
+// ChanPanicHandler handles panics recovered while processing funcs
+type ChanPanicHandler func(err ChanPanicError)
+
 // Stop stops the chan
 func (c *Chan) Stop() {
 	c.mc.Lock()
@@ -148,70 +298,659 @@ func (c *Chan) Stop() {
 	c.mc.Unlock()
 }
 
+// ChanAddOption represents an option that can be provided to Add()
+type ChanAddOption func(i *chanItem)
+
+// ChanAddWithPriority sets the priority of the func. It is only used when order is
+// ChanOrderPriority. Default is 0.
+func ChanAddWithPriority(priority int) ChanAddOption {
+	return func(i *chanItem) {
+		i.priority = priority
+	}
+}
+
+// ChanAddWithKey sets the key of the func. Funcs sharing the same non-empty key are
+// processed in order and never concurrently, which is only relevant when Workers is > 1.
+func ChanAddWithKey(key string) ChanAddOption {
+	return func(i *chanItem) {
+		i.key = key
+	}
+}
+
 // Add adds a new item to the chan
-func (c *Chan) Add(i func()) {
-	// Check context
+func (c *Chan) Add(i func(), opts ...ChanAddOption) {
+	c.add(chanFuncWithoutError(i), nil, false, opts...) //nolint:errcheck
+}
+
+// TryAdd adds a new item to the chan without blocking. It returns ErrChanFull if
+// the chan is full and ErrChanStopped if the chan has been stopped. It doesn't wait
+// for the func to be processed either, even if the add strategy is
+// ChanAddStrategyBlockWhenStarted.
+func (c *Chan) TryAdd(i func(), opts ...ChanAddOption) error {
+	return c.add(chanFuncWithoutError(i), nil, true, opts...)
+}
+
+// AddWithError adds a new item to the chan and returns a future that is resolved with
+// the func error once it has been processed. If the func can't be added or is dropped,
+// the future is resolved with the corresponding error.
+func (c *Chan) AddWithError(i func() error, opts ...ChanAddOption) *ChanFuture {
+	f := newChanFuture()
+	if err := c.add(i, f, false, opts...); err != nil {
+		f.resolve(err)
+	}
+	return f
+}
+
+func chanFuncWithoutError(i func()) func() error {
+	return func() error {
+		i()
+		return nil
+	}
+}
+
+func (c *Chan) isStopped() bool {
 	c.mc.Lock()
-	if c.ctx != nil && c.ctx.Err() != nil {
-		c.mc.Unlock()
-		return
+	defer c.mc.Unlock()
+	return c.ctx != nil && c.ctx.Err() != nil
+}
+
+func (c *Chan) add(i func() error, f *ChanFuture, try bool, opts ...ChanAddOption) error {
+	// Check context
+	if c.isStopped() {
+		return ErrChanStopped
 	}
-	c.mc.Unlock()
 
-	// Wrap the function
-	var fn func()
-	var wg *sync.WaitGroup
-	if c.o.AddStrategy == ChanAddStrategyBlockWhenStarted {
-		wg = &sync.WaitGroup{}
-		wg.Add(1)
-		fn = func() {
-			defer wg.Done()
-			i()
+	// Create item
+	ci := &chanItem{
+		addedAt: now(),
+		f:       f,
+		fn:      i,
+	}
+	if c.o.AddStrategy == ChanAddStrategyBlockWhenStarted && !try {
+		ci.wg = &sync.WaitGroup{}
+		ci.wg.Add(1)
+	}
+	for _, opt := range opts {
+		opt(ci)
+	}
+
+	// Lock
+	c.c.L.Lock()
+
+	// Chan may have been stopped in the meantime, in which case workers may have already
+	// returned
+	if c.isStopped() {
+		c.c.L.Unlock()
+		return ErrChanStopped
+	}
+
+	// Chan is full
+	if c.o.MaxLen > 0 && c.q.len() >= c.o.MaxLen {
+		switch {
+		case try:
+			c.c.L.Unlock()
+			return ErrChanFull
+		case c.o.OverflowStrategy == ChanOverflowStrategyDropNewest:
+			atomic.AddUint64(&c.statDropped, 1)
+			c.c.L.Unlock()
+			ci.done(ErrChanDropped)
+			return nil
+		case c.o.OverflowStrategy == ChanOverflowStrategyDropOldest:
+			atomic.AddUint64(&c.statDropped, 1)
+			c.q.drop().done(ErrChanDropped)
+		default:
+			// Wait for room
+			for c.q.len() >= c.o.MaxLen {
+				if c.isStopped() {
+					c.c.L.Unlock()
+					return ErrChanStopped
+				}
+				c.room.Wait()
+			}
 		}
-	} else {
-		fn = i
 	}
 
 	// Add func to buffer
-	c.mf.Lock()
-	if c.o.Order == ChanOrderFILO {
-		c.fs = append([]func(){fn}, c.fs...)
-	} else {
-		c.fs = append(c.fs, fn)
-	}
-	c.mf.Unlock()
+	c.seq++
+	ci.seq = c.seq
+	c.q.push(ci)
 
 	// Signal
-	c.c.L.Lock()
 	c.c.Signal()
 	c.c.L.Unlock()
 
 	// Wait
-	if wg != nil {
-		wg.Wait()
+	if ci.wg != nil {
+		ci.wg.Wait()
 	}
+	return nil
 }
 
 // Reset resets the chan
 func (c *Chan) Reset() {
-	c.mf.Lock()
-	defer c.mf.Unlock()
-	c.fs = []func(){}
+	c.c.L.Lock()
+	defer c.c.L.Unlock()
+	c.discard(ErrChanDropped)
+}
+
+// discard removes pending and scheduled funcs, and marks them as done with err. It must be
+// called with c locked.
+func (c *Chan) discard(err error) {
+	atomic.AddUint64(&c.statDiscarded, uint64(c.q.len()+c.scheduled.Len()))
+	for c.q.len() > 0 {
+		c.q.pop(nil).done(err)
+	}
+	for c.scheduled.Len() > 0 {
+		heap.Pop(c.scheduled).(*chanItem).done(err)
+	}
+	c.armTimer()
+	c.room.Broadcast()
+}
+
+// ChanTimer represents a func scheduled with AddAfter or AddAt
+type ChanTimer struct {
+	c *Chan
+	i *chanItem
+}
+
+// AddAfter schedules a new item to be added to the chan once d has elapsed. Scheduled
+// funcs are not subject to MaxLen and are not affected by the add strategy. Time is
+// retrieved with Now() so that it can be mocked.
+func (c *Chan) AddAfter(d time.Duration, i func(), opts ...ChanAddOption) *ChanTimer {
+	return c.AddAt(now().Add(d), i, opts...)
+}
+
+// AddAt schedules a new item to be added to the chan at t. See AddAfter.
+func (c *Chan) AddAt(t time.Time, i func(), opts ...ChanAddOption) *ChanTimer {
+	// Create item
+	ci := &chanItem{
+		addedAt: t,
+		fn:      chanFuncWithoutError(i),
+		idx:     -1,
+	}
+	for _, opt := range opts {
+		opt(ci)
+	}
+	ct := &ChanTimer{
+		c: c,
+		i: ci,
+	}
+
+	// Check context
+	if c.isStopped() {
+		return ct
+	}
+
+	// Schedule
+	c.c.L.Lock()
+	if c.isStopped() {
+		c.c.L.Unlock()
+		return ct
+	}
+	c.seq++
+	ci.seq = c.seq
+	heap.Push(c.scheduled, ci)
+	c.armTimer()
+
+	// Signal
+	c.c.Signal()
+	c.c.L.Unlock()
+	return ct
+}
+
+// Cancel cancels the scheduled func. It returns false if the func has already been
+// added to the chan, has already been canceled or couldn't be scheduled.
+func (t *ChanTimer) Cancel() bool {
+	t.c.c.L.Lock()
+	defer t.c.c.L.Unlock()
+	if t.i.idx < 0 {
+		return false
+	}
+	heap.Remove(t.c.scheduled, t.i.idx)
+	t.c.armTimer()
+	return true
+}
+
+// promote adds scheduled funcs that are due to the buffer. It must be called with c locked.
+func (c *Chan) promote() {
+	n := now()
+	for c.scheduled.Len() > 0 && !(*c.scheduled)[0].addedAt.After(n) {
+		i := heap.Pop(c.scheduled).(*chanItem)
+		c.seq++
+		i.seq = c.seq
+		c.q.push(i)
+	}
+}
+
+// armTimer makes sure waiting workers are woken up when the next scheduled func is due.
+// It must be called with c locked.
+func (c *Chan) armTimer() {
+	// No scheduled funcs
+	if c.scheduled.Len() == 0 {
+		if c.timer != nil {
+			c.timer.Stop()
+			c.timer = nil
+		}
+		return
+	}
+
+	// Timer is already armed for the next scheduled func
+	at := (*c.scheduled)[0].addedAt
+	if c.timer != nil && c.timerAt.Equal(at) {
+		return
+	}
+
+	// Arm timer
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.timerAt = at
+	var t *time.Timer
+	t = time.AfterFunc(at.Sub(now()), func() {
+		c.c.L.Lock()
+		defer c.c.L.Unlock()
+		if c.timer == t {
+			c.timer = nil
+		}
+		c.c.Broadcast()
+	})
+	c.timer = t
+}
+
+type chanItem struct {
+	addedAt  time.Time
+	f        *ChanFuture
+	fn       func() error
+	idx      int // Index in the timer heap, -1 if not scheduled
+	key      string
+	priority int
+	seq      uint64
+	wg       *sync.WaitGroup
+}
+
+// done must be called once the item has been either processed or dropped
+func (i *chanItem) done(err error) {
+	if i.f != nil {
+		i.f.resolve(err)
+	}
+	if i.wg != nil {
+		i.wg.Done()
+	}
+}
+
+// ChanFuture represents the result of a func added with AddWithError
+type ChanFuture struct {
+	c   chan struct{}
+	err error
+}
+
+func newChanFuture() *ChanFuture {
+	return &ChanFuture{c: make(chan struct{})}
+}
+
+func (f *ChanFuture) resolve(err error) {
+	f.err = err
+	close(f.c)
+}
+
+// Done returns a channel that is closed once the func has been processed or dropped
+func (f *ChanFuture) Done() <-chan struct{} {
+	return f.c
+}
+
+// Err returns the func error once Done is closed, and nil otherwise
+func (f *ChanFuture) Err() error {
+	select {
+	case <-f.c:
+		return f.err
+	default:
+		return nil
+	}
+}
+
+// Wait blocks until the func has been processed or dropped and returns its error,
+// or until the context is done and returns the context error
+func (f *ChanFuture) Wait(ctx context.Context) error {
+	select {
+	case <-f.c:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// chanQueue represents the pending items of a chan, ordered according to ChanOptions.Order.
+// It's not thread safe: all methods are called with the chan lock held.
+type chanQueue interface {
+	// drop removes and returns the item that must be dropped when the chan is full and
+	// ChanOverflowStrategyDropOldest is used: the first added item for FIFO and FILO
+	// queues, and the item with the lowest aged priority, the most recently added one in
+	// case of a tie, for priority queues. It's only called on a non empty queue.
+	drop() *chanItem
+	// len returns the number of items, which is what MaxLen is compared to
+	len() int
+	// pop removes and returns the next item in order for which skip returns false, or nil
+	// if there's none. skip can be nil.
+	pop(skip func(i *chanItem) bool) *chanItem
+	// push adds an item
+	push(i *chanItem)
+}
+
+func newChanQueue(o ChanOptions) chanQueue {
+	switch o.Order {
+	case ChanOrderFILO:
+		return &chanSliceQueue{filo: true}
+	case ChanOrderPriority:
+		return newChanPriorityQueue(o.PriorityAging)
+	default:
+		return &chanSliceQueue{}
+	}
+}
+
+type chanSliceQueue struct {
+	filo bool
+	is   []*chanItem
+}
+
+func (q *chanSliceQueue) len() int {
+	return len(q.is)
+}
+
+func (q *chanSliceQueue) pop(skip func(i *chanItem) bool) (i *chanItem) {
+	for n := 0; n < len(q.is); n++ {
+		idx := n
+		if q.filo {
+			idx = len(q.is) - 1 - n
+		}
+		if skip != nil && skip(q.is[idx]) {
+			continue
+		}
+		i = q.is[idx]
+		switch idx {
+		case 0:
+			q.is[0] = nil
+			q.is = q.is[1:]
+		case len(q.is) - 1:
+			q.is[idx] = nil
+			q.is = q.is[:idx]
+		default:
+			q.is = append(q.is[:idx], q.is[idx+1:]...)
+		}
+		return
+	}
+	return
+}
+
+func (q *chanSliceQueue) push(i *chanItem) {
+	q.is = append(q.is, i)
+}
+
+// drop removes the first added item, which is popped first in FIFO order and last in FILO
+// order
+func (q *chanSliceQueue) drop() (i *chanItem) {
+	i = q.is[0]
+	q.is[0] = nil
+	q.is = q.is[1:]
+	return
+}
+
+// chanTimerHeap is a min heap of scheduled items sorted by due time, then by sequence
+type chanTimerHeap []*chanItem
+
+func (h chanTimerHeap) Len() int { return len(h) }
+
+func (h chanTimerHeap) Less(i, j int) bool {
+	if !h[i].addedAt.Equal(h[j].addedAt) {
+		return h[i].addedAt.Before(h[j].addedAt)
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h chanTimerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].idx = i
+	h[j].idx = j
+}
+
+func (h *chanTimerHeap) Push(x any) {
+	i := x.(*chanItem)
+	i.idx = len(*h)
+	*h = append(*h, i)
+}
+
+func (h *chanTimerHeap) Pop() any {
+	old := *h
+	i := old[len(old)-1]
+	old[len(old)-1] = nil
+	i.idx = -1
+	*h = old[:len(old)-1]
+	return i
+}
+
+// chanPriorityQueue is a max heap of items sorted by priority, then by sequence
+type chanPriorityQueue struct {
+	aging time.Duration
+	is    []*chanItem
+	lens  map[int]int // Number of items per priority
+	start time.Time
+}
+
+func newChanPriorityQueue(aging time.Duration) *chanPriorityQueue {
+	return &chanPriorityQueue{
+		aging: aging,
+		lens:  make(map[int]int),
+		start: now(),
+	}
+}
+
+// score returns the priority of the item taking aging into account. Since all items
+// age at the same pace, items can be compared using their priority minus the number
+// of aging periods between the queue creation and the moment they were added.
+func (q *chanPriorityQueue) score(i *chanItem) float64 {
+	if q.aging <= 0 {
+		return float64(i.priority)
+	}
+	return float64(i.priority) - float64(i.addedAt.Sub(q.start))/float64(q.aging)
+}
+
+func (q *chanPriorityQueue) Len() int { return len(q.is) }
+
+func (q *chanPriorityQueue) Less(i, j int) bool {
+	if si, sj := q.score(q.is[i]), q.score(q.is[j]); si != sj {
+		return si > sj
+	}
+	return q.is[i].seq < q.is[j].seq
+}
+
+func (q *chanPriorityQueue) Swap(i, j int) { q.is[i], q.is[j] = q.is[j], q.is[i] }
+
+func (q *chanPriorityQueue) Push(x any) { q.is = append(q.is, x.(*chanItem)) }
+
+func (q *chanPriorityQueue) Pop() any {
+	i := q.is[len(q.is)-1]
+	q.is[len(q.is)-1] = nil
+	q.is = q.is[:len(q.is)-1]
+	return i
+}
+
+func (q *chanPriorityQueue) len() int {
+	return len(q.is)
+}
+
+func (q *chanPriorityQueue) pop(skip func(i *chanItem) bool) *chanItem {
+	if skip == nil {
+		return q.remove(0)
+	}
+	idx := -1
+	for j := range q.is {
+		if !skip(q.is[j]) && (idx < 0 || q.Less(j, idx)) {
+			idx = j
+		}
+	}
+	if idx < 0 {
+		return nil
+	}
+	return q.remove(idx)
+}
+
+func (q *chanPriorityQueue) push(i *chanItem) {
+	heap.Push(q, i)
+	q.lens[i.priority]++
+}
+
+// drop removes the item that would be popped last
+func (q *chanPriorityQueue) drop() *chanItem {
+	// Last item is necessarily a leaf
+	idx := len(q.is) / 2
+	for j := idx + 1; j < len(q.is); j++ {
+		if q.Less(idx, j) {
+			idx = j
+		}
+	}
+	return q.remove(idx)
+}
+
+func (q *chanPriorityQueue) remove(idx int) *chanItem {
+	i := heap.Remove(q, idx).(*chanItem)
+	if q.lens[i.priority]--; q.lens[i.priority] == 0 {
+		delete(q.lens, i.priority)
+	}
+	return i
 }
 
 // ChanStats represents the chan stats
 type ChanStats struct {
-	WorkDuration time.Duration
+	// Number of pending or scheduled funcs discarded because the chan was stopped without
+	// ProcessAll or was reset
+	DiscardedCount uint64
+	// Number of funcs dropped because the chan was full, which doesn't include discarded funcs
+	DroppedCount uint64
+	// Average duration between the moment funcs are added and the moment they start
+	// being processed
+	LatencyAvg time.Duration
+	// Max and percentiles are computed over the most recently processed funcs
+	LatencyMax time.Duration
+	LatencyP50 time.Duration
+	LatencyP95 time.Duration
+	LatencyP99 time.Duration
+	// Number of funcs processed
+	ProcessedCount uint64
+	// Number of pending funcs
+	QueueLen int
+	// Number of pending funcs per priority. Only set when order is ChanOrderPriority
+	QueueLenByPriority map[int]int
+	WorkDuration       time.Duration
 }
 
 // Stats returns the chan stats
-func (c *Chan) Stats() ChanStats {
-	return ChanStats{WorkDuration: c.statWorkDuration.Duration()}
+func (c *Chan) Stats() (s ChanStats) {
+	s.DiscardedCount = atomic.LoadUint64(&c.statDiscarded)
+	s.DroppedCount = atomic.LoadUint64(&c.statDropped)
+	s.LatencyAvg = c.statLatencies.avg()
+	ls := c.statLatencies.recent()
+	s.LatencyMax = chanLatencyPercentile(ls, 100)
+	s.LatencyP50 = chanLatencyPercentile(ls, 50)
+	s.LatencyP95 = chanLatencyPercentile(ls, 95)
+	s.LatencyP99 = chanLatencyPercentile(ls, 99)
+	s.ProcessedCount = atomic.LoadUint64(&c.statProcessed)
+	s.WorkDuration = c.statWorkDuration.Duration()
+	c.c.L.Lock()
+	s.QueueLen = c.q.len()
+	if q, ok := c.q.(*chanPriorityQueue); ok {
+		s.QueueLenByPriority = make(map[int]int, len(q.lens))
+		for p, l := range q.lens {
+			s.QueueLenByPriority[p] = l
+		}
+	}
+	c.c.L.Unlock()
+	return
 }
 
 // StatOptions returns the chan stat options
 func (c *Chan) StatOptions() []StatOptions {
 	return []StatOptions{
+		{
+			Metadata: &StatMetadata{
+				Description: "Number of pending or scheduled funcs discarded because the chan was stopped or reset",
+				Label:       "Discarded count",
+				Name:        StatNameDiscardedCount,
+			},
+			Valuer: StatValuerFunc(func(_ time.Duration) any { return atomic.LoadUint64(&c.statDiscarded) }),
+		},
+		{
+			Metadata: &StatMetadata{
+				Description: "Number of funcs dropped because the chan was full",
+				Label:       "Dropped count",
+				Name:        StatNameDroppedCount,
+			},
+			Valuer: StatValuerFunc(func(_ time.Duration) any { return atomic.LoadUint64(&c.statDropped) }),
+		},
+		{
+			Metadata: &StatMetadata{
+				Description: "Average duration between the moment funcs are added and the moment they start being processed",
+				Label:       "Average latency",
+				Name:        StatNameLatencyAvg,
+				Unit:        "ns",
+			},
+			Valuer: NewAtomicDurationAvgStat(c.statLatencies.total, &c.statLatencies.count),
+		},
+		{
+			Metadata: &StatMetadata{
+				Description: "Max duration between the moment the most recent funcs were added and the moment they started being processed",
+				Label:       "Max latency",
+				Name:        StatNameLatencyMax,
+				Unit:        "ns",
+			},
+			Valuer: c.statLatencies.percentileStat(100),
+		},
+		{
+			Metadata: &StatMetadata{
+				Description: "50th percentile of the duration between the moment the most recent funcs were added and the moment they started being processed",
+				Label:       "P50 latency",
+				Name:        StatNameLatencyP50,
+				Unit:        "ns",
+			},
+			Valuer: c.statLatencies.percentileStat(50),
+		},
+		{
+			Metadata: &StatMetadata{
+				Description: "95th percentile of the duration between the moment the most recent funcs were added and the moment they started being processed",
+				Label:       "P95 latency",
+				Name:        StatNameLatencyP95,
+				Unit:        "ns",
+			},
+			Valuer: c.statLatencies.percentileStat(95),
+		},
+		{
+			Metadata: &StatMetadata{
+				Description: "99th percentile of the duration between the moment the most recent funcs were added and the moment they started being processed",
+				Label:       "P99 latency",
+				Name:        StatNameLatencyP99,
+				Unit:        "ns",
+			},
+			Valuer: c.statLatencies.percentileStat(99),
+		},
+		{
+			Metadata: &StatMetadata{
+				Description: "Number of funcs processed per second",
+				Label:       "Processed rate",
+				Name:        StatNameProcessedRate,
+				Unit:        "/s",
+			},
+			Valuer: NewAtomicUint64RateStat(&c.statProcessed),
+		},
+		{
+			Metadata: &StatMetadata{
+				Description: "Number of pending funcs",
+				Label:       "Queue length",
+				Name:        StatNameQueueLength,
+			},
+			Valuer: StatValuerFunc(func(_ time.Duration) any {
+				c.c.L.Lock()
+				defer c.c.L.Unlock()
+				return c.q.len()
+			}),
+		},
 		{
 			Metadata: &StatMetadata{
 				Description: "Percentage of time doing work",
@@ -219,11 +958,101 @@ func (c *Chan) StatOptions() []StatOptions {
 				Name:        StatNameWorkRatio,
 				Unit:        "%",
 			},
-			Valuer: NewAtomicDurationPercentageStat(c.statWorkDuration),
+			Valuer: newChanWorkRatioStat(c.statWorkDuration, c.o.Workers),
 		},
 	}
 }
 
+// Number of recent latencies used to compute max and percentiles
+const chanLatencySamples = 1000
+
+// chanLatencies keeps track of durations between the moment funcs are added and the
+// moment they start being processed
+type chanLatencies struct {
+	count   uint64
+	m       *sync.Mutex // Locks next and samples
+	next    int
+	samples []time.Duration // Ring buffer of the most recent latencies
+	total   *AtomicDuration
+}
+
+func newChanLatencies() *chanLatencies {
+	return &chanLatencies{
+		m:     &sync.Mutex{},
+		total: NewAtomicDuration(0),
+	}
+}
+
+func (l *chanLatencies) add(d time.Duration) {
+	l.total.Add(d)
+	l.m.Lock()
+	if len(l.samples) < chanLatencySamples {
+		l.samples = append(l.samples, d)
+	} else {
+		l.samples[l.next] = d
+	}
+	l.next = (l.next + 1) % chanLatencySamples
+	l.m.Unlock()
+	atomic.AddUint64(&l.count, 1)
+}
+
+func (l *chanLatencies) avg() time.Duration {
+	count := atomic.LoadUint64(&l.count)
+	if count == 0 {
+		return 0
+	}
+	return l.total.Duration() / time.Duration(count)
+}
+
+// recent returns the most recent latencies sorted in ascending order
+func (l *chanLatencies) recent() (ds []time.Duration) {
+	l.m.Lock()
+	ds = make([]time.Duration, len(l.samples))
+	copy(ds, l.samples)
+	l.m.Unlock()
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return
+}
+
+func (l *chanLatencies) percentileStat(p float64) StatValuerFunc {
+	return func(_ time.Duration) any {
+		return chanLatencyPercentile(l.recent(), p)
+	}
+}
+
+// chanLatencyPercentile returns the pth percentile of sorted durations using the
+// nearest-rank method
+func chanLatencyPercentile(ds []time.Duration, p float64) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(p/100*float64(len(ds)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return ds[idx]
+}
+
+// chanWorkRatioStat is the work ratio per worker
+type chanWorkRatioStat struct {
+	s       *AtomicDurationPercentageStat
+	workers int
+}
+
+func newChanWorkRatioStat(d *AtomicDuration, workers int) *chanWorkRatioStat {
+	if workers < 1 {
+		workers = 1
+	}
+	return &chanWorkRatioStat{
+		s:       NewAtomicDurationPercentageStat(d),
+		workers: workers,
+	}
+}
+
+func (s *chanWorkRatioStat) Value(d time.Duration) any {
+	return s.s.Value(d).(float64) / float64(s.workers)
+}
+
 // BufferPool represents a *bytes.Buffer pool
 type BufferPool struct {
 	bp *sync.Pool
@@ -260,17 +1089,21 @@ func (i *BufferPoolItem) Close() error {
 }
 
 // GoroutineLimiter is an object capable of doing several things in parallel while maintaining the
-// max number of things running in parallel under a threshold
+// max number of things running in parallel under a threshold. Things can have a weight in which
+// case the threshold applies to the sum of the weights of the things running in parallel.
 type GoroutineLimiter struct {
-	busy   int
-	c      *sync.Cond
-	ctx    context.Context
-	cancel context.CancelFunc
-	o      GoroutineLimiterOptions
+	active  int // Number of goroutines running
+	busy    int // Sum of the weights of the goroutines running
+	c       *sync.Cond
+	ctx     context.Context
+	cancel  context.CancelFunc
+	o       GoroutineLimiterOptions
+	waiting []*int // Weights of the waiting goroutines, in the order they'll be executed
 }
 
 // GoroutineLimiterOptions represents GoroutineLimiter options
 type GoroutineLimiterOptions struct {
+	// Max sum of the weights of the things running in parallel
 	Max int
 }
 
@@ -305,45 +1138,203 @@ func (l *GoroutineLimiter) handleCtx() {
 type GoroutineLimiterFunc func()
 
 // Do executes custom work in a goroutine
-func (l *GoroutineLimiter) Do(fn GoroutineLimiterFunc) (err error) {
+func (l *GoroutineLimiter) Do(fn GoroutineLimiterFunc) error {
+	return l.DoWeighted(context.Background(), 1, fn)
+}
+
+// DoCtx executes custom work in a goroutine, or returns the context error if the
+// context is cancelled before a goroutine is available
+func (l *GoroutineLimiter) DoCtx(ctx context.Context, fn GoroutineLimiterFunc) error {
+	return l.DoWeighted(ctx, 1, fn)
+}
+
+// DoWeighted executes custom work with a specific weight in a goroutine, or returns the
+// context error if the context is cancelled before enough room is available. Weights
+// are clamped between 1 and the Max option. Waiting funcs are executed in FIFO order.
+func (l *GoroutineLimiter) DoWeighted(ctx context.Context, weight int, fn GoroutineLimiterFunc) (err error) {
 	// Check context in case the limiter has already been closed
 	if err = l.ctx.Err(); err != nil {
 		return
 	}
 
+	// Clamp weight
+	weight = l.clamp(weight)
+
+	// Make sure to wake up when the context is cancelled
+	if ctx.Done() != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				l.c.L.Lock()
+				l.c.Broadcast()
+				l.c.L.Unlock()
+			case <-stop:
+			}
+		}()
+	}
+
+	// Lock
+	l.c.L.Lock()
+
+	// Wait for our turn and for enough room to be available
+	w := &weight
+	l.waiting = append(l.waiting, w)
+	for l.waiting[0] != w || l.busy+weight > l.o.Max {
+		// Check contexts in case the limiter has been closed or the context has
+		// been cancelled while waiting
+		if err = l.ctx.Err(); err == nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			l.removeWaiting(w)
+			l.c.Broadcast()
+			l.c.L.Unlock()
+			return
+		}
+		l.c.Wait()
+	}
+	l.waiting = l.waiting[1:]
+
+	// Check context in case the limiter has been closed while waiting
+	if err = l.ctx.Err(); err != nil {
+		l.c.Broadcast()
+		l.c.L.Unlock()
+		return
+	}
+
+	// Execute
+	l.execute(weight, fn)
+
+	// Next waiting func may fit as well
+	l.c.Broadcast()
+
+	// Unlock
+	l.c.L.Unlock()
+	return
+}
+
+// TryDo executes custom work in a goroutine if one is available right away
+// and returns whether it has been executed
+func (l *GoroutineLimiter) TryDo(fn GoroutineLimiterFunc) bool {
+	return l.TryDoWeighted(1, fn)
+}
+
+// TryDoWeighted executes custom work with a specific weight in a goroutine if enough room
+// is available right away and returns whether it has been executed
+func (l *GoroutineLimiter) TryDoWeighted(weight int, fn GoroutineLimiterFunc) bool {
+	// Check context in case the limiter has already been closed
+	if l.ctx.Err() != nil {
+		return false
+	}
+
+	// Clamp weight
+	weight = l.clamp(weight)
+
 	// Lock
 	l.c.L.Lock()
+	defer l.c.L.Unlock()
+
+	// Not enough room or funcs are already waiting
+	if len(l.waiting) > 0 || l.busy+weight > l.o.Max {
+		return false
+	}
+
+	// Execute
+	l.execute(weight, fn)
+	return true
+}
 
-	// Wait for a goroutine to be available
-	for l.busy >= l.o.Max {
-		l.c.Wait()
+func (l *GoroutineLimiter) clamp(weight int) int {
+	if weight < 1 {
+		return 1
+	} else if weight > l.o.Max {
+		return l.o.Max
 	}
+	return weight
+}
 
-	// Check context in case the limiter has been closed while waiting
-	if err = l.ctx.Err(); err != nil {
-		return
+func (l *GoroutineLimiter) removeWaiting(w *int) {
+	for idx := range l.waiting {
+		if l.waiting[idx] == w {
+			l.waiting = append(l.waiting[:idx], l.waiting[idx+1:]...)
+			return
+		}
 	}
+}
 
+// execute must be called with the limiter locked
+func (l *GoroutineLimiter) execute(weight int, fn GoroutineLimiterFunc) {
 	// Increment
-	l.busy++
-
-	// Unlock
-	l.c.L.Unlock()
+	l.active++
+	l.busy += weight
 
 	// Execute in a goroutine
 	go func() {
 		// Decrement
 		defer func() {
 			l.c.L.Lock()
-			l.busy--
-			l.c.Signal()
+			l.active--
+			l.busy -= weight
+			l.c.Broadcast()
 			l.c.L.Unlock()
 		}()
 
 		// Execute
 		fn()
 	}()
-	return
+}
+
+// GoroutineLimiterStats represents the limiter stats
+type GoroutineLimiterStats struct {
+	// Number of goroutines running
+	ActiveCount int
+	// Sum of the weights of the goroutines running
+	ActiveWeight int
+	// Number of funcs waiting for a goroutine
+	WaitingCount int
+}
+
+// Stats returns the limiter stats
+func (l *GoroutineLimiter) Stats() GoroutineLimiterStats {
+	l.c.L.Lock()
+	defer l.c.L.Unlock()
+	return GoroutineLimiterStats{
+		ActiveCount:  l.active,
+		ActiveWeight: l.busy,
+		WaitingCount: len(l.waiting),
+	}
+}
+
+// StatOptions returns the limiter stat options
+func (l *GoroutineLimiter) StatOptions() []StatOptions {
+	return []StatOptions{
+		{
+			Metadata: &StatMetadata{
+				Description: "Number of goroutines running",
+				Label:       "Active count",
+				Name:        StatNameActiveCount,
+			},
+			Valuer: StatValuerFunc(func(_ time.Duration) any { return l.Stats().ActiveCount }),
+		},
+		{
+			Metadata: &StatMetadata{
+				Description: "Sum of the weights of the goroutines running",
+				Label:       "Active weight",
+				Name:        StatNameActiveWeight,
+			},
+			Valuer: StatValuerFunc(func(_ time.Duration) any { return l.Stats().ActiveWeight }),
+		},
+		{
+			Metadata: &StatMetadata{
+				Description: "Number of funcs waiting for a goroutine",
+				Label:       "Waiting count",
+				Name:        StatNameWaitingCount,
+			},
+			Valuer: StatValuerFunc(func(_ time.Duration) any { return l.Stats().WaitingCount }),
+		},
+	}
 }
 
 // Eventer represents an object that can dispatch simple events (name + payload)
@@ -426,6 +1417,7 @@ type DebugMutex struct {
 	ll              LoggerLevel
 	m               *sync.RWMutex
 	name            string
+	p               *debugMutexProfiler
 	timeout         time.Duration
 }
 
@@ -446,6 +1438,14 @@ func DebugMutexWithDeadlockDetection(timeout time.Duration) DebugMutexOpt {
 	}
 }
 
+// DebugMutexWithContentionProfiling allows gathering per caller contention stats. Check out
+// Profile(), DumpProfile() and StatOptions()
+func DebugMutexWithContentionProfiling() DebugMutexOpt {
+	return func(m *DebugMutex) {
+		m.p = newDebugMutexProfiler()
+	}
+}
+
 // NewDebugMutex creates a new debug mutex
 func NewDebugMutex(name string, l StdLogger, opts ...DebugMutexOpt) *DebugMutex {
 	m := &DebugMutex{
@@ -501,7 +1501,9 @@ func (m *DebugMutex) watchTimeout(caller string, fn func()) {
 func (m *DebugMutex) Lock() {
 	c := m.caller()
 	m.log("astikit: requesting lock for %s at %s", m.name, c)
+	r := m.p.requested(true)
 	m.watchTimeout(c, m.m.Lock)
+	m.p.acquired(c, false, r)
 	m.log("astikit: lock acquired for %s at %s", m.name, c)
 	m.lastCallerMutex.Lock()
 	m.lastCaller = c
@@ -510,6 +1512,7 @@ func (m *DebugMutex) Lock() {
 
 // Unlock write unlocks the mutex
 func (m *DebugMutex) Unlock() {
+	m.p.released(false)
 	m.m.Unlock()
 	m.log("astikit: unlock executed for %s", m.name)
 }
@@ -518,7 +1521,9 @@ func (m *DebugMutex) Unlock() {
 func (m *DebugMutex) RLock() {
 	c := m.caller()
 	m.log("astikit: requesting rlock for %s at %s", m.name, c)
+	r := m.p.requested(false)
 	m.watchTimeout(c, m.m.RLock)
+	m.p.acquired(c, true, r)
 	m.log("astikit: rlock acquired for %s at %s", m.name, c)
 	m.lastCallerMutex.Lock()
 	m.lastCaller = c
@@ -527,10 +1532,346 @@ func (m *DebugMutex) RLock() {
 
 // RUnlock read unlocks the mutex
 func (m *DebugMutex) RUnlock() {
+	m.p.released(true)
 	m.m.RUnlock()
 	m.log("astikit: unlock executed for %s", m.name)
 }
 
+// DebugMutexHistogramBuckets are the upper bounds of the DebugMutexHistogram buckets
+var DebugMutexHistogramBuckets = [...]time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// DebugMutexHistogram represents a duration histogram
+type DebugMutexHistogram struct {
+	// Counts[i] is the number of durations lower than DebugMutexHistogramBuckets[i] and greater than
+	// or equal to DebugMutexHistogramBuckets[i-1]. The last count is the number of durations greater
+	// than or equal to the last bucket.
+	Counts [len(DebugMutexHistogramBuckets) + 1]uint64
+	Max    time.Duration
+	Total  time.Duration
+}
+
+func (h *DebugMutexHistogram) add(d time.Duration) {
+	idx := len(DebugMutexHistogramBuckets)
+	for i, b := range DebugMutexHistogramBuckets {
+		if d < b {
+			idx = i
+			break
+		}
+	}
+	h.Counts[idx]++
+	h.Total += d
+	if d > h.Max {
+		h.Max = d
+	}
+}
+
+func (h DebugMutexHistogram) avg() time.Duration {
+	var count uint64
+	for _, c := range h.Counts {
+		count += c
+	}
+	if count == 0 {
+		return 0
+	}
+	return h.Total / time.Duration(count)
+}
+
+// DebugMutexCallerStats represents the contention stats of a caller
+type DebugMutexCallerStats struct {
+	AcquisitionCount uint64
+	Caller           string
+	HoldDuration     DebugMutexHistogram
+	// Whether the caller read locks the mutex
+	Read         bool
+	WaitDuration DebugMutexHistogram
+}
+
+// DebugMutexBlockingStats represents how much a reader has blocked a writer
+type DebugMutexBlockingStats struct {
+	// Number of times the writer had to wait while the reader was holding the mutex
+	Count  uint64
+	Reader string
+	// Total duration the writer had to wait
+	WaitDuration time.Duration
+	Writer       string
+}
+
+// DebugMutexProfile represents a debug mutex contention profile
+type DebugMutexProfile struct {
+	// Sorted by total hold duration, highest first
+	Callers []DebugMutexCallerStats
+	// Sorted by wait duration, highest first
+	ReadersBlockingWriters []DebugMutexBlockingStats
+}
+
+// Profile returns the contention profile. Contention profiling must be enabled with
+// DebugMutexWithContentionProfiling.
+func (m *DebugMutex) Profile() (p DebugMutexProfile) {
+	if m.p == nil {
+		return
+	}
+	return m.p.profile()
+}
+
+// DumpProfile writes a human readable contention profile into w
+func (m *DebugMutex) DumpProfile(w io.Writer) (err error) {
+	p := m.Profile()
+	if _, err = fmt.Fprintf(w, "astikit: %s mutex contention profile\n", m.name); err != nil {
+		return
+	}
+	for _, c := range p.Callers {
+		l := "lock"
+		if c.Read {
+			l = "rlock"
+		}
+		if _, err = fmt.Fprintf(w, "%s at %s: %d acquisitions, wait avg %s max %s, hold avg %s max %s\n", l, c.Caller, c.AcquisitionCount, c.WaitDuration.avg(), c.WaitDuration.Max, c.HoldDuration.avg(), c.HoldDuration.Max); err != nil {
+			return
+		}
+		for _, h := range []struct {
+			h    DebugMutexHistogram
+			name string
+		}{
+			{h: c.WaitDuration, name: "wait"},
+			{h: c.HoldDuration, name: "hold"},
+		} {
+			var ss []string
+			for idx, b := range DebugMutexHistogramBuckets {
+				ss = append(ss, fmt.Sprintf("<%s: %d", b, h.h.Counts[idx]))
+			}
+			ss = append(ss, fmt.Sprintf(">=%s: %d", DebugMutexHistogramBuckets[len(DebugMutexHistogramBuckets)-1], h.h.Counts[len(DebugMutexHistogramBuckets)]))
+			if _, err = fmt.Fprintf(w, "  %s: %s\n", h.name, strings.Join(ss, ", ")); err != nil {
+				return
+			}
+		}
+	}
+	if len(p.ReadersBlockingWriters) > 0 {
+		if _, err = fmt.Fprintln(w, "readers blocking writers:"); err != nil {
+			return
+		}
+		for _, b := range p.ReadersBlockingWriters {
+			if _, err = fmt.Fprintf(w, "  reader at %s blocked writer at %s %d times for %s\n", b.Reader, b.Writer, b.Count, b.WaitDuration); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// StatOptions returns the debug mutex stat options. Contention profiling must be enabled with
+// DebugMutexWithContentionProfiling.
+func (m *DebugMutex) StatOptions() []StatOptions {
+	if m.p == nil {
+		return nil
+	}
+	return []StatOptions{
+		{
+			Metadata: &StatMetadata{
+				Description: fmt.Sprintf("Number of %s mutex acquisitions per second", m.name),
+				Label:       "Acquisition rate",
+				Name:        StatNameAcquisitionRate,
+				Unit:        "/s",
+			},
+			Valuer: NewAtomicUint64RateStat(&m.p.acquisitions),
+		},
+		{
+			Metadata: &StatMetadata{
+				Description: fmt.Sprintf("Average duration spent holding the %s mutex", m.name),
+				Label:       "Average hold duration",
+				Name:        StatNameHoldAvg,
+				Unit:        "ns",
+			},
+			Valuer: NewAtomicDurationAvgStat(m.p.holdDuration, &m.p.releases),
+		},
+		{
+			Metadata: &StatMetadata{
+				Description: fmt.Sprintf("Average duration spent waiting for the %s mutex", m.name),
+				Label:       "Average wait duration",
+				Name:        StatNameWaitAvg,
+				Unit:        "ns",
+			},
+			Valuer: NewAtomicDurationAvgStat(m.p.waitDuration, &m.p.acquisitions),
+		},
+	}
+}
+
+type debugMutexHolder struct {
+	acquiredAt time.Time
+	caller     string
+	read       bool
+}
+
+type debugMutexRequest struct {
+	readers     []string // Callers holding a read lock when a write lock has been requested
+	requestedAt time.Time
+}
+
+type debugMutexProfiler struct {
+	acquisitions uint64
+	blocking     map[[2]string]*DebugMutexBlockingStats
+	callers      map[debugMutexCallerKey]*DebugMutexCallerStats
+	holdDuration *AtomicDuration
+	m            *sync.Mutex        // Locks blocking, callers, readers and writer
+	readers      []debugMutexHolder // Read holders, oldest first
+	releases     uint64
+	waitDuration *AtomicDuration
+	writer       *debugMutexHolder
+}
+
+type debugMutexCallerKey struct {
+	caller string
+	read   bool
+}
+
+func newDebugMutexProfiler() *debugMutexProfiler {
+	return &debugMutexProfiler{
+		blocking:     make(map[[2]string]*DebugMutexBlockingStats),
+		callers:      make(map[debugMutexCallerKey]*DebugMutexCallerStats),
+		holdDuration: NewAtomicDuration(0),
+		m:            &sync.Mutex{},
+		waitDuration: NewAtomicDuration(0),
+	}
+}
+
+func (p *debugMutexProfiler) requested(write bool) (r debugMutexRequest) {
+	if p == nil {
+		return
+	}
+	r.requestedAt = now()
+	if write {
+		p.m.Lock()
+		for _, h := range p.readers {
+			r.readers = append(r.readers, h.caller)
+		}
+		p.m.Unlock()
+	}
+	return
+}
+
+func (p *debugMutexProfiler) acquired(caller string, read bool, r debugMutexRequest) {
+	if p == nil {
+		return
+	}
+
+	// Get wait duration
+	n := now()
+	wait := n.Sub(r.requestedAt)
+	p.waitDuration.Add(wait)
+	atomic.AddUint64(&p.acquisitions, 1)
+
+	// Lock
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	// Update caller stats
+	k := debugMutexCallerKey{caller: caller, read: read}
+	s, ok := p.callers[k]
+	if !ok {
+		s = &DebugMutexCallerStats{Caller: caller, Read: read}
+		p.callers[k] = s
+	}
+	s.AcquisitionCount++
+	s.WaitDuration.add(wait)
+
+	// Update readers blocking writers
+	for _, reader := range r.readers {
+		bk := [2]string{reader, caller}
+		b, ok := p.blocking[bk]
+		if !ok {
+			b = &DebugMutexBlockingStats{Reader: reader, Writer: caller}
+			p.blocking[bk] = b
+		}
+		b.Count++
+		b.WaitDuration += wait
+	}
+
+	// Store holder
+	h := debugMutexHolder{
+		acquiredAt: n,
+		caller:     caller,
+		read:       read,
+	}
+	if read {
+		p.readers = append(p.readers, h)
+	} else {
+		p.writer = &h
+	}
+}
+
+func (p *debugMutexProfiler) released(read bool) {
+	if p == nil {
+		return
+	}
+
+	// Lock
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	// Get holder
+	var h debugMutexHolder
+	if read {
+		// Read unlocks don't tell which read lock they release and may happen in a
+		// different goroutine, therefore the oldest reader is released. When several
+		// read locks are held at the same time, hold durations are approximate but
+		// the number of readers always matches.
+		if len(p.readers) == 0 {
+			return
+		}
+		h = p.readers[0]
+		p.readers = p.readers[1:]
+	} else {
+		if p.writer == nil {
+			return
+		}
+		h = *p.writer
+		p.writer = nil
+	}
+
+	// Update stats
+	d := now().Sub(h.acquiredAt)
+	p.holdDuration.Add(d)
+	atomic.AddUint64(&p.releases, 1)
+	if s, ok := p.callers[debugMutexCallerKey{caller: h.caller, read: h.read}]; ok {
+		s.HoldDuration.add(d)
+	}
+}
+
+func (p *debugMutexProfiler) profile() (o DebugMutexProfile) {
+	// Lock
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	// Copy
+	for _, s := range p.callers {
+		o.Callers = append(o.Callers, *s)
+	}
+	for _, b := range p.blocking {
+		o.ReadersBlockingWriters = append(o.ReadersBlockingWriters, *b)
+	}
+
+	// Sort
+	sort.Slice(o.Callers, func(i, j int) bool {
+		if o.Callers[i].HoldDuration.Total != o.Callers[j].HoldDuration.Total {
+			return o.Callers[i].HoldDuration.Total > o.Callers[j].HoldDuration.Total
+		}
+		return o.Callers[i].Caller < o.Callers[j].Caller
+	})
+	sort.Slice(o.ReadersBlockingWriters, func(i, j int) bool {
+		if o.ReadersBlockingWriters[i].WaitDuration != o.ReadersBlockingWriters[j].WaitDuration {
+			return o.ReadersBlockingWriters[i].WaitDuration > o.ReadersBlockingWriters[j].WaitDuration
+		}
+		return o.ReadersBlockingWriters[i].Reader < o.ReadersBlockingWriters[j].Reader
+	})
+	return
+}
+
 type AtomicDuration struct {
 	d time.Duration
 	m *sync.Mutex
@@ -557,75 +1898,229 @@ func (d *AtomicDuration) Duration() time.Duration {
 
 // FIFOMutex is a mutex guaranteeing FIFO order
 type FIFOMutex struct {
-	busy    bool
-	m       sync.Mutex // Locks busy and waiting
-	waiting []*sync.Cond
+	m fifoMutex
 }
 
 func (m *FIFOMutex) Lock() {
+	m.m.lock(context.Background(), false) //nolint:errcheck
+}
+
+// LockCtx locks the mutex or returns the context error if the context is cancelled
+// before the mutex could be locked, in which case the caller is removed from the
+// waiting queue
+func (m *FIFOMutex) LockCtx(ctx context.Context) error {
+	return m.m.lock(ctx, false)
+}
+
+// TryLock locks the mutex if it's not locked and nobody is waiting for it, and returns
+// whether it has been locked
+func (m *FIFOMutex) TryLock() bool {
+	return m.m.tryLock(false)
+}
+
+func (m *FIFOMutex) Unlock() {
+	m.m.unlock(false)
+}
+
+// FIFORWMutex is a reader/writer mutex guaranteeing FIFO order between readers and writers:
+// a reader requesting the mutex while a writer is waiting for it will wait for the writer to
+// unlock it, and consecutive waiting readers acquire the mutex together
+type FIFORWMutex struct {
+	m fifoMutex
+}
+
+func (m *FIFORWMutex) Lock() {
+	m.m.lock(context.Background(), false) //nolint:errcheck
+}
+
+// LockCtx write locks the mutex or returns the context error if the context is cancelled
+// before the mutex could be locked, in which case the caller is removed from the waiting
+// queue
+func (m *FIFORWMutex) LockCtx(ctx context.Context) error {
+	return m.m.lock(ctx, false)
+}
+
+// TryLock write locks the mutex if it's not locked and nobody is waiting for it, and
+// returns whether it has been locked
+func (m *FIFORWMutex) TryLock() bool {
+	return m.m.tryLock(false)
+}
+
+func (m *FIFORWMutex) Unlock() {
+	m.m.unlock(false)
+}
+
+func (m *FIFORWMutex) RLock() {
+	m.m.lock(context.Background(), true) //nolint:errcheck
+}
+
+// RLockCtx read locks the mutex or returns the context error if the context is cancelled
+// before the mutex could be locked, in which case the caller is removed from the waiting
+// queue
+func (m *FIFORWMutex) RLockCtx(ctx context.Context) error {
+	return m.m.lock(ctx, true)
+}
+
+// TryRLock read locks the mutex if it's not write locked and nobody is waiting for it, and
+// returns whether it has been locked
+func (m *FIFORWMutex) TryRLock() bool {
+	return m.m.tryLock(true)
+}
+
+func (m *FIFORWMutex) RUnlock() {
+	m.m.unlock(true)
+}
+
+type fifoMutex struct {
+	m       sync.Mutex // Locks readers, waiting and writer
+	readers int
+	waiting []*fifoMutexWaiter
+	writer  bool
+}
+
+type fifoMutexWaiter struct {
+	c    chan struct{} // Closed once the mutex has been acquired
+	read bool
+}
+
+func (m *fifoMutex) canAcquire(read bool) bool {
+	if read {
+		return !m.writer
+	}
+	return !m.writer && m.readers == 0
+}
+
+func (m *fifoMutex) acquire(read bool) {
+	if read {
+		m.readers++
+	} else {
+		m.writer = true
+	}
+}
+
+func (m *fifoMutex) release(read bool) {
+	if read {
+		if m.readers > 0 {
+			m.readers--
+		}
+	} else {
+		m.writer = false
+	}
+}
+
+// grant acquires the mutex for waiters at the head of the waiting queue, as long as possible
+func (m *fifoMutex) grant() {
+	for len(m.waiting) > 0 && m.canAcquire(m.waiting[0].read) {
+		m.acquire(m.waiting[0].read)
+		close(m.waiting[0].c)
+		m.waiting[0] = nil
+		m.waiting = m.waiting[1:]
+	}
+}
+
+func (m *fifoMutex) lock(ctx context.Context, read bool) error {
 	// No need to wait
 	m.m.Lock()
-	if !m.busy {
-		m.busy = true
+	if len(m.waiting) == 0 && m.canAcquire(read) {
+		m.acquire(read)
 		m.m.Unlock()
-		return
+		return nil
 	}
 
-	// Create cond
-	c := sync.NewCond(&sync.Mutex{})
-
-	// Make sure to lock cond when waiting mutex is still held
-	c.L.Lock()
-
 	// Add to waiting queue
-	m.waiting = append(m.waiting, c)
+	w := &fifoMutexWaiter{
+		c:    make(chan struct{}),
+		read: read,
+	}
+	m.waiting = append(m.waiting, w)
 	m.m.Unlock()
 
 	// Wait
-	c.Wait()
+	select {
+	case <-w.c:
+		return nil
+	case <-ctx.Done():
+		m.m.Lock()
+		defer m.m.Unlock()
+		select {
+		case <-w.c:
+			// Mutex has been acquired in the meantime
+			m.release(read)
+		default:
+			// Remove from waiting queue
+			for idx := range m.waiting {
+				if m.waiting[idx] == w {
+					m.waiting = append(m.waiting[:idx], m.waiting[idx+1:]...)
+					break
+				}
+			}
+		}
+
+		// Next waiters may be able to acquire the mutex now
+		m.grant()
+		return ctx.Err()
+	}
 }
 
-func (m *FIFOMutex) Unlock() {
-	// Lock
+func (m *fifoMutex) tryLock(read bool) bool {
 	m.m.Lock()
 	defer m.m.Unlock()
-
-	// Waiting queue is empty
-	if len(m.waiting) == 0 {
-		m.busy = false
-		return
+	if len(m.waiting) > 0 || !m.canAcquire(read) {
+		return false
 	}
+	m.acquire(read)
+	return true
+}
 
-	// Signal and remove first item in waiting queue
-	m.waiting[0].L.Lock()
-	m.waiting[0].Signal()
-	m.waiting[0].L.Unlock()
-	m.waiting = m.waiting[1:]
+func (m *fifoMutex) unlock(read bool) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.release(read)
+	m.grant()
 }
 
 // BufferedBatcher is a Chan-like object that:
 //   - processes all added items in the provided callback as a batch so that they're all processed together
 //   - doesn't block when adding an item while a batch is being processed but add it to the next batch
 //   - if an item is added several times to the same batch, it will be processed only once in the next batch
+//
+// Check out BufferedBatcherOptions to control batch sizes, flush triggers and ordering
 type BufferedBatcher struct {
-	batch   map[any]bool // Locked by c's mutex
-	c       *sync.Cond
-	cancel  context.CancelFunc
-	ctx     context.Context
-	mc      sync.Mutex // Locks cancel and ctx
-	onBatch BufferedBatcherOnBatchFunc
+	batch        map[any]bool // Locked by c's mutex
+	c            *sync.Cond
+	cancel       context.CancelFunc
+	ctx          context.Context
+	firstAddedAt time.Time  // Locked by c's mutex
+	mc           sync.Mutex // Locks cancel and ctx
+	o            BufferedBatcherOptions
+	onBatch      BufferedBatcherOnBatchFunc
+	order        []any // Locked by c's mutex
 }
 
 type BufferedBatcherOnBatchFunc func(ctx context.Context, batch []any)
 
 type BufferedBatcherOptions struct {
-	OnBatch BufferedBatcherOnBatchFunc
+	// If true, items that have not yet been processed when the batcher is stopped are
+	// processed in a final batch. The callback is then provided a context that is not
+	// cancelled.
+	FlushOnStop bool
+	// If > 0, maximum number of items in a batch. Remaining items are processed in the
+	// next batch.
+	MaxBatchSize int
+	// If > 0, a batch is processed once MaxLinger has elapsed since its first item was added,
+	// or once it contains MaxBatchSize items. By default a batch is processed as soon as
+	// the previous one has been processed.
+	MaxLinger time.Duration
+	OnBatch   BufferedBatcherOnBatchFunc
+	// If true, items are processed in the order they were first added to the batch
+	Ordered bool
 }
 
 func NewBufferedBatcher(o BufferedBatcherOptions) *BufferedBatcher {
 	return &BufferedBatcher{
 		batch:   make(map[any]bool),
 		c:       sync.NewCond(&sync.Mutex{}),
+		o:       o,
 		onBatch: o.OnBatch,
 	}
 }
@@ -662,6 +2157,9 @@ func (bb *BufferedBatcher) Start(ctx context.Context) {
 	for {
 		// Context has been canceled
 		if ctx.Err() != nil {
+			if bb.o.FlushOnStop {
+				bb.flush()
+			}
 			return
 		}
 
@@ -673,14 +2171,23 @@ func (bb *BufferedBatcher) Start(ctx context.Context) {
 			continue
 		}
 
-		// Copy batch into a slice
-		var batch []any
-		for i := range bb.batch {
-			batch = append(batch, i)
+		// Wait for the batch to linger
+		if bb.o.MaxLinger > 0 && (bb.o.MaxBatchSize <= 0 || len(bb.batch) < bb.o.MaxBatchSize) {
+			if d := bb.firstAddedAt.Add(bb.o.MaxLinger).Sub(now()); d > 0 {
+				t := time.AfterFunc(d, func() {
+					bb.c.L.Lock()
+					bb.c.Signal()
+					bb.c.L.Unlock()
+				})
+				bb.c.Wait()
+				bb.c.L.Unlock()
+				t.Stop()
+				continue
+			}
 		}
 
-		// Reset batch
-		bb.batch = map[any]bool{}
+		// Take batch
+		batch := bb.take()
 
 		// Unlock
 		bb.c.L.Unlock()
@@ -690,13 +2197,75 @@ func (bb *BufferedBatcher) Start(ctx context.Context) {
 	}
 }
 
+// flush processes all remaining items
+func (bb *BufferedBatcher) flush() {
+	for {
+		bb.c.L.Lock()
+		batch := bb.take()
+		bb.c.L.Unlock()
+		if len(batch) == 0 {
+			return
+		}
+		bb.onBatch(context.Background(), batch)
+	}
+}
+
+// take removes the next batch from the items that have not yet been processed. It must be
+// called with c's mutex locked.
+func (bb *BufferedBatcher) take() (batch []any) {
+	// Get batch size
+	n := len(bb.batch)
+	if bb.o.MaxBatchSize > 0 && n > bb.o.MaxBatchSize {
+		n = bb.o.MaxBatchSize
+	}
+	if n == 0 {
+		return
+	}
+
+	// Copy batch into a slice
+	batch = make([]any, 0, n)
+	if bb.o.Ordered {
+		batch = append(batch, bb.order[:n]...)
+		bb.order = bb.order[n:]
+	} else {
+		for i := range bb.batch {
+			if len(batch) == n {
+				break
+			}
+			batch = append(batch, i)
+		}
+	}
+
+	// Remove batch items
+	if n == len(bb.batch) {
+		bb.batch = map[any]bool{}
+		bb.order = nil
+	} else {
+		for _, i := range batch {
+			delete(bb.batch, i)
+		}
+	}
+	return
+}
+
 func (bb *BufferedBatcher) Add(i any) {
 	// Lock
 	bb.c.L.Lock()
 	defer bb.c.L.Unlock()
 
+	// Already stored
+	if bb.batch[i] {
+		return
+	}
+
 	// Store
+	if len(bb.batch) == 0 {
+		bb.firstAddedAt = now()
+	}
 	bb.batch[i] = true
+	if bb.o.Ordered {
+		bb.order = append(bb.order, i)
+	}
 
 	// Signal
 	bb.c.Signal()
@@ -299,3 +299,894 @@ func TestBufferedBatcher(t *testing.T) {
 		t.Fatal("expected nothing, got timeout")
 	}
 }
+
+func TestChanPriority(t *testing.T) {
+	// No aging
+	c := NewChan(ChanOptions{
+		Order:      ChanOrderPriority,
+		ProcessAll: true,
+	})
+	var o []int
+	c.Add(func() { o = append(o, 1) })
+	c.Add(func() { o = append(o, 2) }, ChanAddWithPriority(2))
+	c.Add(func() { o = append(o, 3) }, ChanAddWithPriority(-1))
+	c.Add(func() { o = append(o, 4) }, ChanAddWithPriority(2))
+	c.Add(func() { o = append(o, 5) })
+	if e, g := map[int]int{-1: 1, 0: 2, 2: 2}, c.Stats().QueueLenByPriority; !reflect.DeepEqual(e, g) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	c.Add(func() { c.Stop() }, ChanAddWithPriority(-2))
+	c.Start(context.Background())
+	if e := []int{2, 4, 1, 5, 3}; !reflect.DeepEqual(o, e) {
+		t.Fatalf("expected %+v, got %+v", e, o)
+	}
+	if e, g := map[int]int{}, c.Stats().QueueLenByPriority; !reflect.DeepEqual(e, g) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+
+	// Aging
+	n := time.Unix(0, 0)
+	defer MockNow(func() time.Time { return n }).Close()
+	c = NewChan(ChanOptions{
+		Order:         ChanOrderPriority,
+		PriorityAging: time.Second,
+		ProcessAll:    true,
+	})
+	o = []int{}
+	c.Add(func() { o = append(o, 1) })
+	n = n.Add(3 * time.Second)
+	c.Add(func() { o = append(o, 2) }, ChanAddWithPriority(2))
+	c.Add(func() { o = append(o, 3) }, ChanAddWithPriority(3))
+	c.Add(func() { o = append(o, 4) }, ChanAddWithPriority(4))
+	c.Add(func() { c.Stop() }, ChanAddWithPriority(-10))
+	c.Start(context.Background())
+	if e := []int{4, 1, 3, 2}; !reflect.DeepEqual(o, e) {
+		t.Fatalf("expected %+v, got %+v", e, o)
+	}
+}
+
+func TestChanMaxLen(t *testing.T) {
+	// Try add
+	c := NewChan(ChanOptions{MaxLen: 1, ProcessAll: true})
+	var o []int
+	if err := c.TryAdd(func() { o = append(o, 1) }); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e, g := ErrChanFull, c.TryAdd(func() { o = append(o, 2) }); !errors.Is(g, e) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	c.Reset()
+	if err := c.TryAdd(func() { c.Stop() }); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	c.Start(context.Background())
+	if e, g := ErrChanStopped, c.TryAdd(func() {}); !errors.Is(g, e) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	if len(o) > 0 {
+		t.Fatalf("expected no funcs, got %+v", o)
+	}
+
+	// Drop newest
+	c = NewChan(ChanOptions{
+		MaxLen:           2,
+		OverflowStrategy: ChanOverflowStrategyDropNewest,
+		ProcessAll:       true,
+	})
+	o = []int{}
+	c.Add(func() { o = append(o, 1) })
+	c.Add(func() { o = append(o, 2); c.Stop() })
+	c.Add(func() { o = append(o, 3) })
+	c.Start(context.Background())
+	if e := []int{1, 2}; !reflect.DeepEqual(o, e) {
+		t.Fatalf("expected %+v, got %+v", e, o)
+	}
+	if e, g := uint64(1), c.Stats().DroppedCount; e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+
+	// Drop oldest
+	c = NewChan(ChanOptions{
+		MaxLen:           2,
+		OverflowStrategy: ChanOverflowStrategyDropOldest,
+		ProcessAll:       true,
+	})
+	o = []int{}
+	c.Add(func() { o = append(o, 1) })
+	c.Add(func() { o = append(o, 2) })
+	c.Add(func() { o = append(o, 3); c.Stop() })
+	c.Start(context.Background())
+	if e := []int{2, 3}; !reflect.DeepEqual(o, e) {
+		t.Fatalf("expected %+v, got %+v", e, o)
+	}
+	if e, g := uint64(1), c.Stats().DroppedCount; e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+
+	// Drop oldest with priority
+	c = NewChan(ChanOptions{
+		MaxLen:           3,
+		Order:            ChanOrderPriority,
+		OverflowStrategy: ChanOverflowStrategyDropOldest,
+		ProcessAll:       true,
+	})
+	o = []int{}
+	c.Add(func() { o = append(o, 1) }, ChanAddWithPriority(1))
+	c.Add(func() { o = append(o, 2) }, ChanAddWithPriority(-1))
+	c.Add(func() { o = append(o, 3) }, ChanAddWithPriority(2))
+	c.Add(func() { o = append(o, 4); c.Stop() })
+	c.Start(context.Background())
+	if e := []int{3, 1, 4}; !reflect.DeepEqual(o, e) {
+		t.Fatalf("expected %+v, got %+v", e, o)
+	}
+
+	// Block
+	c = NewChan(ChanOptions{MaxLen: 1, ProcessAll: true})
+	o = []int{}
+	added := make(chan bool)
+	c.Add(func() { o = append(o, 1) })
+	go func() {
+		c.Add(func() { o = append(o, 2); c.Stop() })
+		close(added)
+	}()
+	select {
+	case <-added:
+		t.Fatal("expected add to block")
+	case <-time.After(10 * time.Millisecond):
+	}
+	c.Start(context.Background())
+	<-added
+	if e := []int{1, 2}; !reflect.DeepEqual(o, e) {
+		t.Fatalf("expected %+v, got %+v", e, o)
+	}
+}
+
+func TestChanWorkers(t *testing.T) {
+	// Funcs are processed concurrently
+	c := NewChan(ChanOptions{ProcessAll: true, Workers: 3})
+	const n = 3
+	wg := &sync.WaitGroup{}
+	wg.Add(n)
+	for idx := 0; idx < n; idx++ {
+		c.Add(func() {
+			wg.Done()
+			wg.Wait()
+		})
+	}
+	c.Add(func() { c.Stop() })
+	c.Start(context.Background())
+
+	// Keys
+	c = NewChan(ChanOptions{ProcessAll: true, Workers: 4})
+	m := &sync.Mutex{}
+	o := make(map[string][]int)
+	running := make(map[string]bool)
+	for idx := 0; idx < 20; idx++ {
+		idx := idx
+		k := "a"
+		if idx%2 == 1 {
+			k = "b"
+		}
+		c.Add(func() {
+			m.Lock()
+			if running[k] {
+				t.Errorf("expected %s not to be running", k)
+			}
+			running[k] = true
+			m.Unlock()
+			time.Sleep(time.Millisecond)
+			m.Lock()
+			running[k] = false
+			o[k] = append(o[k], idx)
+			m.Unlock()
+		}, ChanAddWithKey(k))
+	}
+	c.Add(func() { c.Stop() })
+	c.Start(context.Background())
+	if e := (map[string][]int{
+		"a": {0, 2, 4, 6, 8, 10, 12, 14, 16, 18},
+		"b": {1, 3, 5, 7, 9, 11, 13, 15, 17, 19},
+	}); !reflect.DeepEqual(e, o) {
+		t.Fatalf("expected %+v, got %+v", e, o)
+	}
+
+	// Work ratio is per worker
+	s := newChanWorkRatioStat(NewAtomicDuration(4*time.Second), 4)
+	if e, g := 50.0, s.Value(2*time.Second); e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+}
+
+func TestChanAddWithError(t *testing.T) {
+	// Errors and panics
+	l := &mockedStdLogger{}
+	var ps []ChanPanicError
+	c := NewChan(ChanOptions{
+		Logger:       l,
+		PanicHandler: func(err ChanPanicError) { ps = append(ps, err) },
+		ProcessAll:   true,
+	})
+	err1 := errors.New("1")
+	f1 := c.AddWithError(func() error { return err1 })
+	f2 := c.AddWithError(func() error { panic("2") })
+	f3 := c.AddWithError(func() error { return nil })
+	if err := f1.Err(); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	c.Add(func() { c.Stop() })
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		c.Start(context.Background())
+	}()
+	if e, g := err1, f1.Wait(context.Background()); !errors.Is(g, e) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	if e, g := err1, f1.Err(); !errors.Is(g, e) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	var pe ChanPanicError
+	if err := f2.Wait(context.Background()); !errors.As(err, &pe) {
+		t.Fatalf("expected ChanPanicError, got %+v", err)
+	}
+	if e, g := "2", pe.Value; e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	if e, g := "astikit: func panicked: 2", pe.Error(); e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	<-f3.Done()
+	if err := f3.Err(); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if e, g := 1, len(ps); e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	l.m.Lock()
+	ss := l.ss
+	l.m.Unlock()
+	if e, g := 1, len(ss); e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	if s, g := "print: astikit: func panicked: 2", ss[0]; !strings.HasPrefix(g, s) {
+		t.Fatalf("%s doesn't start with %s", g, s)
+	}
+	<-done
+
+	// Dropped and stopped
+	c = NewChan(ChanOptions{MaxLen: 1, OverflowStrategy: ChanOverflowStrategyDropNewest})
+	c.Add(func() {})
+	if e, g := ErrChanDropped, c.AddWithError(func() error { return nil }).Err(); !errors.Is(g, e) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	c.Reset()
+	f := c.AddWithError(func() error { return nil })
+	c.Reset()
+	if e, g := ErrChanDropped, f.Err(); !errors.Is(g, e) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	c.Add(func() { c.Stop() })
+	c.Start(context.Background())
+	if e, g := ErrChanStopped, c.AddWithError(func() error { return nil }).Err(); !errors.Is(g, e) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if e, g := context.Canceled, NewChan(ChanOptions{}).AddWithError(func() error { return nil }).Wait(ctx); !errors.Is(g, e) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+}
+
+func TestChanStats(t *testing.T) {
+	n := time.Unix(0, 0)
+	m := &sync.Mutex{}
+	defer MockNow(func() time.Time {
+		m.Lock()
+		defer m.Unlock()
+		return n
+	}).Close()
+	c := NewChan(ChanOptions{
+		MaxLen:           5,
+		OverflowStrategy: ChanOverflowStrategyDropNewest,
+		ProcessAll:       true,
+	})
+	for idx := 0; idx < 4; idx++ {
+		c.Add(func() {})
+		m.Lock()
+		n = n.Add(10 * time.Millisecond)
+		m.Unlock()
+	}
+	m.Lock()
+	n = time.Unix(0, 0).Add(100 * time.Millisecond)
+	m.Unlock()
+	c.Add(func() { c.Stop() })
+	c.Add(func() {})
+	if e, g := 5, c.Stats().QueueLen; e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	c.Start(context.Background())
+	s := c.Stats()
+	s.WorkDuration = 0
+	if e := (ChanStats{
+		DroppedCount:   1,
+		LatencyAvg:     68 * time.Millisecond,
+		LatencyMax:     100 * time.Millisecond,
+		LatencyP50:     80 * time.Millisecond,
+		LatencyP95:     100 * time.Millisecond,
+		LatencyP99:     100 * time.Millisecond,
+		ProcessedCount: 5,
+	}); !reflect.DeepEqual(e, s) {
+		t.Fatalf("expected %+v, got %+v", e, s)
+	}
+
+	vs := make(map[string]any)
+	for _, o := range c.StatOptions() {
+		vs[o.Metadata.Name] = o.Valuer.(StatValuer).Value(time.Second)
+	}
+	if e := map[string]any{
+		StatNameDiscardedCount: uint64(0),
+		StatNameDroppedCount:   uint64(1),
+		StatNameLatencyAvg:     68 * time.Millisecond,
+		StatNameLatencyMax:     100 * time.Millisecond,
+		StatNameLatencyP50:     80 * time.Millisecond,
+		StatNameLatencyP95:     100 * time.Millisecond,
+		StatNameLatencyP99:     100 * time.Millisecond,
+		StatNameProcessedRate:  5.0,
+		StatNameQueueLength:    0,
+		StatNameWorkRatio:      vs[StatNameWorkRatio],
+	}; !reflect.DeepEqual(e, vs) {
+		t.Fatalf("expected %+v, got %+v", e, vs)
+	}
+
+	// Discarded on reset
+	c = NewChan(ChanOptions{})
+	c.Add(func() {})
+	c.AddAfter(time.Hour, func() {})
+	c.Reset()
+	if e, g := uint64(2), c.Stats().DiscardedCount; e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+
+	// Discarded on stop
+	c.Add(func() { c.Stop() })
+	c.Add(func() {})
+	c.Start(context.Background())
+	if e, g := uint64(3), c.Stats().DiscardedCount; e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	if e, g := uint64(0), c.Stats().DroppedCount; e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+}
+
+func TestChanAddAfter(t *testing.T) {
+	// Mocked time
+	n := time.Unix(0, 0)
+	m := &sync.Mutex{}
+	defer MockNow(func() time.Time {
+		m.Lock()
+		defer m.Unlock()
+		return n
+	}).Close()
+	c := NewChan(ChanOptions{ProcessAll: true})
+	var o []int
+	c.AddAfter(2*time.Second, func() { o = append(o, 2) })
+	c.AddAt(time.Unix(1, 0), func() { o = append(o, 1) })
+	t3 := c.AddAfter(3*time.Second, func() { o = append(o, 3) })
+	c.AddAfter(2*time.Second, func() { o = append(o, 22) })
+	c.Add(func() { o = append(o, 0) })
+	if !t3.Cancel() {
+		t.Fatal("expected true, got false")
+	}
+	if t3.Cancel() {
+		t.Fatal("expected false, got true")
+	}
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		c.Start(context.Background())
+	}()
+	m.Lock()
+	n = time.Unix(5, 0)
+	m.Unlock()
+	c.Add(func() { c.Stop() })
+	<-done
+	if e := []int{0, 1, 2, 22}; !reflect.DeepEqual(o, e) {
+		t.Fatalf("expected %+v, got %+v", e, o)
+	}
+	if c.AddAfter(time.Second, func() {}).Cancel() {
+		t.Fatal("expected false, got true")
+	}
+
+	// Reset
+	c = NewChan(ChanOptions{})
+	t1 := c.AddAfter(time.Second, func() {})
+	c.Reset()
+	if t1.Cancel() {
+		t.Fatal("expected false, got true")
+	}
+}
+
+func TestChanAddAfterProcessAll(t *testing.T) {
+	c := NewChan(ChanOptions{ProcessAll: true})
+	var o []int
+	c.AddAfter(5*time.Millisecond, func() { o = append(o, 1) })
+	c.Add(func() { c.Stop() })
+	c.Start(context.Background())
+	if e := []int{1}; !reflect.DeepEqual(o, e) {
+		t.Fatalf("expected %+v, got %+v", e, o)
+	}
+}
+
+func TestGoroutineLimiterWeighted(t *testing.T) {
+	l := NewGoroutineLimiter(GoroutineLimiterOptions{Max: 4})
+	defer l.Close()
+	release := make(chan bool)
+	started := make(chan bool)
+	fn := func() {
+		started <- true
+		<-release
+	}
+	if err := l.DoWeighted(context.Background(), 3, fn); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	<-started
+	if l.TryDoWeighted(2, fn) {
+		t.Fatal("expected false, got true")
+	}
+	if !l.TryDo(fn) {
+		t.Fatal("expected true, got false")
+	}
+	<-started
+	if e, g := (GoroutineLimiterStats{ActiveCount: 2, ActiveWeight: 4}), l.Stats(); e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+
+	// Context is cancelled while waiting
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if e, g := context.DeadlineExceeded, l.DoCtx(ctx, fn); !errors.Is(g, e) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+
+	// Waiting funcs are executed in FIFO order and weights are clamped
+	var o []int
+	m := &sync.Mutex{}
+	errs := make(chan error)
+	for idx, w := range []int{10, 1} {
+		idx := idx
+		go func(w int) {
+			errs <- l.DoWeighted(context.Background(), w, func() {
+				m.Lock()
+				o = append(o, idx)
+				m.Unlock()
+				fn()
+			})
+		}(w)
+		for l.Stats().WaitingCount != idx+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if l.TryDo(fn) {
+		t.Fatal("expected false, got true")
+	}
+	vs := make(map[string]any)
+	for _, o := range l.StatOptions() {
+		vs[o.Metadata.Name] = o.Valuer.(StatValuer).Value(time.Second)
+	}
+	if e := map[string]any{
+		StatNameActiveCount:  2,
+		StatNameActiveWeight: 4,
+		StatNameWaitingCount: 2,
+	}; !reflect.DeepEqual(e, vs) {
+		t.Fatalf("expected %+v, got %+v", e, vs)
+	}
+	release <- true
+	release <- true
+	for idx := 0; idx < 2; idx++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+		<-started
+		release <- true
+	}
+	m.Lock()
+	if e := []int{0, 1}; !reflect.DeepEqual(e, o) {
+		t.Fatalf("expected %+v, got %+v", e, o)
+	}
+	m.Unlock()
+
+	// Limiter is closed while waiting
+	if err := l.DoWeighted(context.Background(), 4, fn); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	<-started
+	go func() {
+		errs <- l.Do(fn)
+	}()
+	for l.Stats().WaitingCount != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	l.Close()
+	if err := <-errs; err == nil {
+		t.Fatal("expected error")
+	}
+	release <- true
+}
+
+func TestBufferedBatcherOptions(t *testing.T) {
+	// Ordered, max batch size and flush on stop
+	var bb *BufferedBatcher
+	var batches [][]any
+	var errs []error
+	bb = NewBufferedBatcher(BufferedBatcherOptions{
+		FlushOnStop:  true,
+		MaxBatchSize: 2,
+		OnBatch: func(ctx context.Context, batch []any) {
+			batches = append(batches, batch)
+			errs = append(errs, ctx.Err())
+			bb.Stop()
+		},
+		Ordered: true,
+	})
+	for _, i := range []int{3, 1, 3, 2, 5, 4} {
+		bb.Add(i)
+	}
+	bb.Start(context.Background())
+	if e := [][]any{{3, 1}, {2, 5}, {4}}; !reflect.DeepEqual(e, batches) {
+		t.Fatalf("expected %+v, got %+v", e, batches)
+	}
+	if e := []error{nil, nil, nil}; !reflect.DeepEqual(e, errs) {
+		t.Fatalf("expected %+v, got %+v", e, errs)
+	}
+
+	// Max linger
+	for _, v := range []struct {
+		maxBatchSize int
+		maxLinger    time.Duration
+		minDuration  time.Duration
+	}{
+		{maxLinger: 20 * time.Millisecond, minDuration: 20 * time.Millisecond},
+		{maxBatchSize: 2, maxLinger: time.Hour},
+	} {
+		batches = [][]any{}
+		n := time.Now()
+		var d time.Duration
+		bb = NewBufferedBatcher(BufferedBatcherOptions{
+			MaxBatchSize: v.maxBatchSize,
+			MaxLinger:    v.maxLinger,
+			OnBatch: func(ctx context.Context, batch []any) {
+				d = time.Since(n)
+				batches = append(batches, batch)
+				bb.Stop()
+			},
+			Ordered: true,
+		})
+		bb.Add(1)
+		go func() {
+			time.Sleep(5 * time.Millisecond)
+			bb.Add(2)
+		}()
+		bb.Start(context.Background())
+		if e := [][]any{{1, 2}}; !reflect.DeepEqual(e, batches) {
+			t.Fatalf("expected %+v, got %+v", e, batches)
+		}
+		if d < v.minDuration {
+			t.Fatalf("expected %s to be >= %s", d, v.minDuration)
+		}
+	}
+}
+
+func TestDebugMutexContentionProfiling(t *testing.T) {
+	m := NewDebugMutex("test", nil, DebugMutexWithContentionProfiling())
+	m.RLock()
+	locked := make(chan bool)
+	go func() {
+		m.Lock()
+		close(locked)
+		m.Unlock()
+	}()
+	time.Sleep(20 * time.Millisecond)
+	m.RUnlock()
+	<-locked
+
+	p := m.Profile()
+	if e, g := 2, len(p.Callers); e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	var r, w DebugMutexCallerStats
+	for _, c := range p.Callers {
+		if !strings.Contains(c.Caller, "sync_test.go:") {
+			t.Fatalf("%s doesn't contain sync_test.go:", c.Caller)
+		}
+		if c.Read {
+			r = c
+		} else {
+			w = c
+		}
+	}
+	for _, c := range []DebugMutexCallerStats{r, w} {
+		if e, g := uint64(1), c.AcquisitionCount; e != g {
+			t.Fatalf("expected %+v, got %+v", e, g)
+		}
+	}
+	if r.HoldDuration.Total < 20*time.Millisecond {
+		t.Fatalf("expected %s to be >= 20ms", r.HoldDuration.Total)
+	}
+	if e, g := uint64(1), r.HoldDuration.Counts[5]; e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	if w.WaitDuration.Max < 15*time.Millisecond {
+		t.Fatalf("expected %s to be >= 15ms", w.WaitDuration.Max)
+	}
+	if e, g := 1, len(p.ReadersBlockingWriters); e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	if b := p.ReadersBlockingWriters[0]; b.Reader != r.Caller || b.Writer != w.Caller || b.Count != 1 || b.WaitDuration != w.WaitDuration.Total {
+		t.Fatalf("invalid blocking stats %+v", b)
+	}
+
+	buf := &strings.Builder{}
+	if err := m.DumpProfile(buf); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	for _, s := range []string{
+		"astikit: test mutex contention profile\n",
+		"rlock at " + r.Caller + ": 1 acquisitions",
+		"  hold: <1µs: 0, <10µs: 0, <100µs: 0, <1ms: 0, <10ms: 0, <100ms: 1, <1s: 0, >=1s: 0\n",
+		"readers blocking writers:\n  reader at " + r.Caller + " blocked writer at " + w.Caller + " 1 times for ",
+	} {
+		if !strings.Contains(buf.String(), s) {
+			t.Fatalf("%s doesn't contain %s", buf.String(), s)
+		}
+	}
+
+	vs := make(map[string]any)
+	for _, o := range m.StatOptions() {
+		vs[o.Metadata.Name] = o.Valuer.(StatValuer).Value(time.Second)
+	}
+	if e, g := 2.0, vs[StatNameAcquisitionRate]; e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	if e, g := (r.HoldDuration.Total+w.HoldDuration.Total)/2, vs[StatNameHoldAvg]; e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+}
+
+func TestFIFOMutexLockCtx(t *testing.T) {
+	m := &FIFOMutex{}
+	if !m.TryLock() {
+		t.Fatal("expected true, got false")
+	}
+	if m.TryLock() {
+		t.Fatal("expected false, got true")
+	}
+
+	// Cancelled waiter is removed from the queue
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error)
+	go func() { errC <- m.LockCtx(ctx) }()
+	for {
+		m.m.m.Lock()
+		l := len(m.m.waiting)
+		m.m.m.Unlock()
+		if l == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-errC; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %+v", err)
+	}
+	if e, g := 0, len(m.m.waiting); e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	m.Unlock()
+	if !m.TryLock() {
+		t.Fatal("expected true, got false")
+	}
+	m.Unlock()
+}
+
+func TestFIFORWMutex(t *testing.T) {
+	m := &FIFORWMutex{}
+	waitFor := func(n int) {
+		for {
+			m.m.m.Lock()
+			l := len(m.m.waiting)
+			m.m.m.Unlock()
+			if l == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// Readers share the mutex
+	m.RLock()
+	if !m.TryRLock() {
+		t.Fatal("expected true, got false")
+	}
+	if m.TryLock() {
+		t.Fatal("expected false, got true")
+	}
+
+	// A waiting writer blocks new readers, and readers behind it are admitted together
+	var mo sync.Mutex
+	var o []string
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		m.Lock()
+		mo.Lock()
+		o = append(o, "w")
+		mo.Unlock()
+		m.Unlock()
+	}()
+	waitFor(1)
+	if m.TryRLock() {
+		t.Fatal("expected false, got true")
+	}
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer wg.Done()
+			m.RLock()
+			mo.Lock()
+			o = append(o, "r")
+			mo.Unlock()
+			m.RUnlock()
+		}()
+		waitFor(2 + i)
+	}
+	m.RUnlock()
+	m.RUnlock()
+	wg.Wait()
+	if e, g := []string{"w", "r", "r"}, o; !reflect.DeepEqual(e, g) {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+
+	// Cancelling a waiting writer lets readers behind it through
+	m.RLock()
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error)
+	go func() { errC <- m.LockCtx(ctx) }()
+	waitFor(1)
+	rC := make(chan error)
+	go func() { rC <- m.RLockCtx(context.Background()) }()
+	waitFor(2)
+	cancel()
+	if err := <-errC; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %+v", err)
+	}
+	if err := <-rC; err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	m.RUnlock()
+	m.RUnlock()
+	if !m.TryLock() {
+		t.Fatal("expected true, got false")
+	}
+	m.Unlock()
+}
+
+func TestChanStopWithPendingFuncs(t *testing.T) {
+	c := NewChan(ChanOptions{})
+	f1 := c.AddWithError(func() error {
+		c.Stop()
+		return nil
+	})
+	f2 := c.AddWithError(func() error { return nil })
+	ct := c.AddAfter(time.Hour, func() {})
+	c.Start(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := f1.Wait(ctx); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if err := f2.Wait(ctx); !errors.Is(err, ErrChanStopped) {
+		t.Fatalf("expected ErrChanStopped, got %+v", err)
+	}
+	if ct.Cancel() {
+		t.Fatal("expected false, got true")
+	}
+	if e, g := 0, c.Stats().QueueLen; e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	if err := c.AddWithError(func() error { return nil }).Wait(ctx); !errors.Is(err, ErrChanStopped) {
+		t.Fatalf("expected ErrChanStopped, got %+v", err)
+	}
+
+	// Block when started
+	c = NewChan(ChanOptions{AddStrategy: ChanAddStrategyBlockWhenStarted})
+	go c.Start(context.Background())
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go c.Add(func() {
+		close(started)
+		<-release
+		c.Stop()
+	})
+	<-started
+	added := make(chan struct{})
+	go func() {
+		defer close(added)
+		c.Add(func() {})
+	}()
+	for c.Stats().QueueLen == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("expected add to be done")
+	}
+}
+
+func TestDebugMutexContentionProfilingRUnlockInOtherGoroutine(t *testing.T) {
+	m := NewDebugMutex("test", nil, DebugMutexWithContentionProfiling())
+	m.RLock()
+	time.Sleep(10 * time.Millisecond)
+	done := make(chan bool)
+	go func() {
+		m.RUnlock()
+		close(done)
+	}()
+	<-done
+	m.Lock()
+	m.Unlock()
+
+	p := m.Profile()
+	if e, g := 0, len(p.ReadersBlockingWriters); e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+	for _, c := range p.Callers {
+		var n uint64
+		for _, v := range c.HoldDuration.Counts {
+			n += v
+		}
+		if e, g := uint64(1), n; e != g {
+			t.Fatalf("expected %+v, got %+v", e, g)
+		}
+		if c.Read && c.HoldDuration.Total < 10*time.Millisecond {
+			t.Fatalf("expected %s to be >= 10ms", c.HoldDuration.Total)
+		}
+	}
+	if e, g := 0, len(m.p.readers); e != g {
+		t.Fatalf("expected %+v, got %+v", e, g)
+	}
+}
+
+func TestChanTryAddBlockWhenStarted(t *testing.T) {
+	c := NewChan(ChanOptions{AddStrategy: ChanAddStrategyBlockWhenStarted})
+	stopped := make(chan bool)
+	go func() {
+		c.Start(context.Background())
+		close(stopped)
+	}()
+	defer func() {
+		c.Stop()
+		<-stopped
+	}()
+	release := make(chan bool)
+	processed := make(chan bool)
+	done := make(chan error)
+	go func() {
+		done <- c.TryAdd(func() {
+			<-release
+			close(processed)
+		})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected TryAdd not to block")
+	}
+	close(release)
+	<-processed
+}